@@ -1,27 +1,199 @@
 package config
 
+import "encoding/json"
+
 const (
 	// MappingFile mapping file environment variable
 	MappingFile = "MAPPING_FILE"
 	CaCertFile  = "CA_CERT_FILE"
 	CertFile    = "CERT_FILE"
 	KeyFile     = "KEY_FILE"
+	// ShutdownTimeout environment variable with time to wait for in-flight messages on shutdown
+	ShutdownTimeout = "SHUTDOWN_TIMEOUT"
 )
 
-// RabbitEntry RabbitMQ mapping entry
+// URLs connection URLs, mapping file accepts a single URL or a list of URLs
+type URLs []string
+
+// UnmarshalJSON reads a single URL or a list of URLs
+func (u *URLs) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*u = nil
+		if single != "" {
+			*u = URLs{single}
+		}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*u = list
+	return nil
+}
+
+// RabbitEntry RabbitMQ/SQS source mapping entry
 type RabbitEntry struct {
-	Type          string   `json:"type"`
-	Name          string   `json:"name"`
-	ConnectionURL string   `json:"connection"`
-	ExchangeName  string   `json:"topic"`
-	QueueName     string   `json:"queue"`
-	RoutingKey    string   `json:"routing"`
-	RoutingKeys   []string `json:"routingKeys"`
+	Type              string          `json:"type"`
+	Name              string          `json:"name"`
+	ConnectionURLs    URLs            `json:"connection"`
+	Shuffle           bool            `json:"shuffle"`
+	TLS               *TLSEntry       `json:"tls"`
+	AMQP              *AMQPEntry      `json:"amqp"`
+	ExchangeName      string          `json:"topic"`
+	QueueName         string          `json:"queue"`
+	RoutingKey        string          `json:"routing"`
+	RoutingKeys       []string        `json:"routingKeys"`
+	Topology          *TopologyEntry  `json:"topology"`
+	Transform         *TransformEntry `json:"transform"`
+	Filter            []FilterEntry   `json:"filter"`
+	Delivery          string          `json:"delivery"`
+	Dedup             *DedupEntry     `json:"dedup"`
+	Reconnect         *ReconnectEntry `json:"reconnect"`
+	Stream            *StreamEntry    `json:"stream"`
+	WaitTimeSeconds   int64           `json:"waitTimeSeconds"`
+	MaxMessages       int64           `json:"maxMessages"`
+	VisibilityTimeout int64           `json:"visibilityTimeout"`
+}
+
+// TopologyEntry exchange and queue declared by RabbitMQ consumer. In passive mode
+// existing exchange and queue are only checked, nothing is declared or bound
+type TopologyEntry struct {
+	Passive              bool                   `json:"passive"`
+	ExchangeType         string                 `json:"exchangeType"`
+	QueueType            string                 `json:"queueType"`
+	MaxLength            int64                  `json:"maxLength"`
+	MessageTTL           string                 `json:"messageTTL"`
+	Lazy                 bool                   `json:"lazy"`
+	SingleActiveConsumer bool                   `json:"singleActiveConsumer"`
+	DeliveryLimit        int                    `json:"deliveryLimit"`
+	RequeueDelay         string                 `json:"requeueDelay"`
+	Arguments            map[string]interface{} `json:"arguments"`
+	Bindings             []BindingEntry         `json:"bindings"`
+}
+
+// BindingEntry queue binding, headers are matched by headers exchange
+type BindingEntry struct {
+	RoutingKey string                 `json:"routing"`
+	Headers    map[string]interface{} `json:"headers"`
+	Match      string                 `json:"match"`
+}
+
+// StreamEntry RabbitMQ stream consumer settings
+type StreamEntry struct {
+	Offset         string `json:"offset"`
+	OffsetFile     string `json:"offsetFile"`
+	CommitInterval int    `json:"commitInterval"`
+	Prefetch       int    `json:"prefetch"`
+}
+
+// TransformEntry message transformation applied before forwarding
+type TransformEntry struct {
+	Drop     []string          `json:"drop"`
+	Rename   map[string]string `json:"rename"`
+	Envelope string            `json:"envelope"`
+	Metadata map[string]string `json:"metadata"`
+	Template string            `json:"template"`
+}
+
+// TLSEntry TLS settings of amqps connection, empty files fall back to environment variables
+type TLSEntry struct {
+	CACert             string `json:"caCert"`
+	Cert               string `json:"cert"`
+	Key                string `json:"key"`
+	ServerName         string `json:"serverName"`
+	MinVersion         string `json:"minVersion"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify"`
+	ReloadInterval     string `json:"reloadInterval"`
+}
+
+// AMQPEntry AMQP connection settings, empty values use the library defaults
+type AMQPEntry struct {
+	Auth           string `json:"auth"`
+	Vhost          string `json:"vhost"`
+	Heartbeat      string `json:"heartbeat"`
+	FrameSize      int    `json:"frameSize"`
+	ChannelMax     int    `json:"channelMax"`
+	Locale         string `json:"locale"`
+	ConnectionName string `json:"connectionName"`
+}
+
+// ReconnectEntry RabbitMQ reconnect backoff settings
+type ReconnectEntry struct {
+	Interval    string `json:"interval"`
+	MaxInterval string `json:"maxInterval"`
+	MaxAttempts int    `json:"maxAttempts"`
+}
+
+// DedupEntry message deduplication settings
+type DedupEntry struct {
+	Key             string `json:"key"`
+	Window          string `json:"window"`
+	Store           string `json:"store"`
+	Size            int    `json:"size"`
+	Table           string `json:"table"`
+	RedeliveredOnly bool   `json:"redeliveredOnly"`
 }
 
-// AmazonEntry SQS/SNS mapping entry
+// FilterEntry condition on message field, every condition of a filter has to match
+type FilterEntry struct {
+	Field   string   `json:"field"`
+	Equals  *string  `json:"equals"`
+	In      []string `json:"in"`
+	Pattern string   `json:"pattern"`
+	Exists  *bool    `json:"exists"`
+	Not     bool     `json:"not"`
+}
+
+// AmazonEntry Amazon service mapping entry
 type AmazonEntry struct {
-	Type   string `json:"type"`
-	Name   string `json:"name"`
-	Target string `json:"target"`
+	Type               string            `json:"type"`
+	Name               string            `json:"name"`
+	Target             string            `json:"target"`
+	Offload            *OffloadEntry     `json:"offload"`
+	PartitionKey       string            `json:"partitionKey"`
+	Batch              *BatchEntry       `json:"batch"`
+	Source             string            `json:"source"`
+	SourceField        string            `json:"sourceField"`
+	DetailType         string            `json:"detailType"`
+	DetailTypeField    string            `json:"detailTypeField"`
+	Key                string            `json:"key"`
+	Gzip               bool              `json:"gzip"`
+	Keys               map[string]string `json:"keys"`
+	TTLAttribute       string            `json:"ttlAttribute"`
+	TTL                string            `json:"ttl"`
+	MessageIDAttribute string            `json:"messageIdAttribute"`
+	Method             string            `json:"method"`
+	Headers            map[string]string `json:"headers"`
+	Timeout            string            `json:"timeout"`
+	SuccessCodes       []int             `json:"successCodes"`
+	Retries            int               `json:"retries"`
+	HMACSecretEnv      string            `json:"hmacSecretEnv"`
+	SignatureHeader    string            `json:"signatureHeader"`
+	InvocationType     string            `json:"invocationType"`
+	Qualifier          string            `json:"qualifier"`
+	ConnectionURLs     URLs              `json:"connection"`
+	Shuffle            bool              `json:"shuffle"`
+	TLS                *TLSEntry         `json:"tls"`
+	AMQP               *AMQPEntry        `json:"amqp"`
+	RoutingKey         string            `json:"routing"`
+	Mandatory          bool              `json:"mandatory"`
+}
+
+// BatchEntry batching of forwarded messages
+type BatchEntry struct {
+	Size     int    `json:"size"`
+	Interval string `json:"interval"`
+}
+
+// OffloadEntry S3 bucket for payloads exceeding SNS/SQS size limit
+type OffloadEntry struct {
+	Bucket         string `json:"bucket"`
+	Prefix         string `json:"prefix"`
+	Threshold      int    `json:"threshold"`
+	Always         bool   `json:"always"`
+	Region         string `json:"region"`
+	Endpoint       string `json:"endpoint"`
+	ForcePathStyle bool   `json:"forcePathStyle"`
 }
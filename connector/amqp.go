@@ -0,0 +1,154 @@
+package connector
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/streadway/amqp"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
+)
+
+const (
+	// PlainAuth SASL PLAIN with credentials of the connection URL
+	PlainAuth = "plain"
+	// AMQPlainAuth SASL AMQPLAIN with credentials of the connection URL
+	AMQPlainAuth = "amqplain"
+	// ExternalAuth SASL EXTERNAL, the broker authenticates the client certificate
+	ExternalAuth = "external"
+	// DefaultHeartbeat heartbeat interval proposed to the broker
+	DefaultHeartbeat = 10 * time.Second
+	// DefaultLocale connection locale
+	DefaultLocale = "en_US"
+	// Product name of the client reported to the broker
+	Product = "rabbit-amazon-forwarder"
+)
+
+// externalAuthentication SASL EXTERNAL mechanism, identity is taken from the client certificate
+type externalAuthentication struct {
+}
+
+func (a *externalAuthentication) Mechanism() string {
+	return "EXTERNAL"
+}
+
+func (a *externalAuthentication) Response() string {
+	return ""
+}
+
+// CreateConnectorWithConfig creates connector with AMQP and TLS settings of the mapping entry.
+// Name is reported to the broker as connection name unless the entry sets its own one
+func CreateConnectorWithConfig(name string, amqpEntry *config.AMQPEntry, tlsEntry *config.TLSEntry, connectionURLs ...string) (RabbitConnector, error) {
+	amqpConfig, auth, err := createConfig(name, amqpEntry)
+	if err != nil {
+		return nil, err
+	}
+	rabbitConnector, err := CreateConnectorWithTLS(tlsEntry, connectionURLs...)
+	if err != nil {
+		return nil, err
+	}
+	switch c := rabbitConnector.(type) {
+	case *BasicRabbitConnector:
+		if auth == ExternalAuth {
+			return nil, fmt.Errorf("%s authentication requires amqps connection", ExternalAuth)
+		}
+		c.Config, c.Auth = amqpConfig, auth
+	case *TlsRabbitConnector:
+		if auth == ExternalAuth {
+			if err := c.checkExternalAuth(connectionURLs); err != nil {
+				return nil, err
+			}
+		}
+		c.Config, c.Auth = amqpConfig, auth
+	}
+	return rabbitConnector, nil
+}
+
+// checkExternalAuth checks that every connection uses TLS with client certificate
+func (c *TlsRabbitConnector) checkExternalAuth(connectionURLs []string) error {
+	for _, connectionURL := range connectionURLs {
+		if !strings.HasPrefix(connectionURL, "amqps") {
+			return fmt.Errorf("%s authentication requires amqps connection, found %s", ExternalAuth, Redact(connectionURL))
+		}
+	}
+	certificate, err := c.clientCertificate()
+	if err != nil {
+		return err
+	}
+	if certificate == nil {
+		return fmt.Errorf("%s authentication requires client certificate", ExternalAuth)
+	}
+	return nil
+}
+
+func defaultConfig() amqp.Config {
+	return amqp.Config{
+		Heartbeat:  DefaultHeartbeat,
+		Locale:     DefaultLocale,
+		Properties: amqp.Table{"product": Product},
+	}
+}
+
+// createConfig creates connection settings and authentication mechanism from mapping entry
+func createConfig(name string, entry *config.AMQPEntry) (amqp.Config, string, error) {
+	amqpConfig := defaultConfig()
+	if name != "" {
+		amqpConfig.Properties["connection_name"] = name
+	}
+	if entry == nil {
+		return amqpConfig, PlainAuth, nil
+	}
+	auth := strings.ToLower(entry.Auth)
+	switch auth {
+	case "":
+		auth = PlainAuth
+	case PlainAuth, AMQPlainAuth, ExternalAuth:
+	default:
+		return amqpConfig, "", fmt.Errorf("unknown authentication mechanism %s, expected one of %s, %s, %s", entry.Auth, PlainAuth, AMQPlainAuth, ExternalAuth)
+	}
+	if entry.Heartbeat != "" {
+		heartbeat, err := time.ParseDuration(entry.Heartbeat)
+		if err != nil {
+			return amqpConfig, "", err
+		}
+		if heartbeat < 0 {
+			return amqpConfig, "", fmt.Errorf("heartbeat cannot be negative, found %s", entry.Heartbeat)
+		}
+		amqpConfig.Heartbeat = heartbeat
+	}
+	if entry.FrameSize < 0 || entry.ChannelMax < 0 {
+		return amqpConfig, "", fmt.Errorf("frame size and channel max cannot be negative, found %d and %d", entry.FrameSize, entry.ChannelMax)
+	}
+	amqpConfig.FrameSize = entry.FrameSize
+	amqpConfig.ChannelMax = entry.ChannelMax
+	amqpConfig.Vhost = entry.Vhost
+	if entry.Locale != "" {
+		amqpConfig.Locale = entry.Locale
+	}
+	if entry.ConnectionName != "" {
+		amqpConfig.Properties["connection_name"] = entry.ConnectionName
+	}
+	return amqpConfig, auth, nil
+}
+
+// connectionConfig prepares settings of a single connection. Client properties are copied,
+// because the library adds capabilities to them while opening the connection
+func connectionConfig(base amqp.Config, auth string, connectionURL string) (amqp.Config, error) {
+	dialConfig := base
+	dialConfig.Properties = amqp.Table{}
+	for key, value := range base.Properties {
+		dialConfig.Properties[key] = value
+	}
+	switch auth {
+	case AMQPlainAuth:
+		uri, err := amqp.ParseURI(connectionURL)
+		if err != nil {
+			return dialConfig, err
+		}
+		dialConfig.SASL = []amqp.Authentication{&amqp.AMQPlainAuth{Username: uri.Username, Password: uri.Password}}
+	case ExternalAuth:
+		dialConfig.SASL = []amqp.Authentication{&externalAuthentication{}}
+	}
+	return dialConfig, nil
+}
@@ -0,0 +1,44 @@
+package connector
+
+import (
+	"errors"
+	"math/rand"
+	"net/url"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/streadway/amqp"
+)
+
+// Dial connects to the first available broker of the cluster. URLs are tried in order,
+// or in random order when shuffle is set so reconnecting clients spread over cluster nodes
+func Dial(rabbitConnector RabbitConnector, connectionURLs []string, shuffle bool) (*amqp.Connection, error) {
+	if len(connectionURLs) == 0 {
+		return nil, errors.New("no RabbitMQ connection URL configured")
+	}
+	urls := append([]string(nil), connectionURLs...)
+	if shuffle {
+		rand.Shuffle(len(urls), func(i, j int) { urls[i], urls[j] = urls[j], urls[i] })
+	}
+	var err error
+	for _, connectionURL := range urls {
+		var conn *amqp.Connection
+		if conn, err = rabbitConnector.CreateConnection(connectionURL); err == nil {
+			log.WithField("broker", Redact(connectionURL)).Info("Connected to RabbitMQ")
+			return conn, nil
+		}
+		log.WithFields(log.Fields{
+			"broker": Redact(connectionURL),
+			"error":  err.Error()}).Warn("Could not connect to RabbitMQ broker")
+	}
+	return nil, err
+}
+
+// Redact hides password of the connection URL so it can be logged
+func Redact(connectionURL string) string {
+	u, err := url.Parse(connectionURL)
+	if err != nil {
+		return "invalid URL"
+	}
+	return u.Redacted()
+}
@@ -4,8 +4,9 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"io/ioutil"
-	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/AirHelp/rabbit-amazon-forwarder/config"
 	log "github.com/sirupsen/logrus"
@@ -49,25 +50,26 @@ func (x *X509KeyPairLoader) LoadKeyPair(certFile string, keyFile string) (tls.Ce
 }
 
 type RabbitDialer interface {
-	Dial(connectionURL string) (*amqp.Connection, error)
+	Dial(connectionURL string, config amqp.Config) (*amqp.Connection, error)
 }
 
 type BasicRabbitDialer struct {
 }
 
-func (s *BasicRabbitDialer) Dial(connectionURL string) (*amqp.Connection, error) {
-	return amqp.Dial(connectionURL)
+func (s *BasicRabbitDialer) Dial(connectionURL string, config amqp.Config) (*amqp.Connection, error) {
+	return amqp.DialConfig(connectionURL, config)
 }
 
+// TlsRabbitDialer dials with TLS settings of config TLSClientConfig
 type TlsRabbitDialer interface {
-	DialTLS(connectionURL string, tlsConfig *tls.Config) (*amqp.Connection, error)
+	DialTLS(connectionURL string, config amqp.Config) (*amqp.Connection, error)
 }
 
 type X509TlsDialer struct {
 }
 
-func (s *X509TlsDialer) DialTLS(connectionURL string, tlsConfig *tls.Config) (*amqp.Connection, error) {
-	return amqp.DialTLS(connectionURL, tlsConfig)
+func (s *X509TlsDialer) DialTLS(connectionURL string, config amqp.Config) (*amqp.Connection, error) {
+	return amqp.DialConfig(connectionURL, config)
 }
 
 type RabbitConnector interface {
@@ -76,11 +78,18 @@ type RabbitConnector interface {
 
 type BasicRabbitConnector struct {
 	BasicRabbitDialer RabbitDialer
+	// Config AMQP connection settings, SASL mechanisms are chosen by Auth
+	Config amqp.Config
+	Auth   string
 }
 
 func (c *BasicRabbitConnector) CreateConnection(connectionURL string) (*amqp.Connection, error) {
 	log.Info("Dialing in")
-	return c.BasicRabbitDialer.Dial(connectionURL)
+	dialConfig, err := connectionConfig(c.Config, c.Auth, connectionURL)
+	if err != nil {
+		return nil, err
+	}
+	return c.BasicRabbitDialer.Dial(connectionURL, dialConfig)
 }
 
 type TlsRabbitConnector struct {
@@ -89,11 +98,23 @@ type TlsRabbitConnector struct {
 	CertPoolMaker CertPoolMaker
 	KeyLoader     KeyLoader
 	TlsDialer     TlsRabbitDialer
+	// Config AMQP connection settings, SASL mechanisms are chosen by Auth
+	Config amqp.Config
+	Auth   string
+	// CaCertFile, CertFile and KeyFile override CA_CERT_FILE, CERT_FILE and KEY_FILE environment variables
+	CaCertFile string
+	CertFile   string
+	KeyFile    string
+	// ReloadInterval how often certificate files are checked for changes, disabled when zero
+	ReloadInterval time.Duration
+	watcher        *certificateWatcher
+	mutex          sync.Mutex
+	certificate    *tls.Certificate
 }
 
 func (c *TlsRabbitConnector) CreateConnection(connectionURL string) (*amqp.Connection, error) {
 	log.Info("Dialing in via TLS")
-	caCertFilePath := os.Getenv(config.CaCertFile)
+	caCertFilePath := fileOrEnv(c.CaCertFile, config.CaCertFile)
 
 	if ca, err := c.FileReader.ReadFile(caCertFilePath); err == nil {
 		c.TlsConfig.RootCAs = c.CertPoolMaker.NewCertPoolWithAppendedCa(ca)
@@ -104,22 +125,27 @@ func (c *TlsRabbitConnector) CreateConnection(connectionURL string) (*amqp.Conne
 		return nil, err
 	}
 
-	certFilePath := os.Getenv(config.CertFile)
-	keyFilePath := os.Getenv(config.KeyFile)
-	if _, err := c.KeyLoader.LoadKeyPair(certFilePath, keyFilePath); err == nil {
-		c.TlsConfig.Certificates = append(c.TlsConfig.Certificates, cert)
-	} else {
-		log.WithFields(log.Fields{
-			"error":         err.Error(),
-			config.CertFile: certFilePath,
-			config.KeyFile:  keyFilePath}).Info("Error loading client certificates")
+	certificate, err := c.clientCertificate()
+	if err != nil {
+		return nil, err
 	}
-	return c.TlsDialer.DialTLS(connectionURL, c.TlsConfig)
+	if certificate != nil {
+		c.TlsConfig.Certificates = []tls.Certificate{*certificate}
+	}
+	dialConfig, err := connectionConfig(c.Config, c.Auth, connectionURL)
+	if err != nil {
+		return nil, err
+	}
+	// the library sets server name of the dialed host on the provided config, every cluster node
+	// needs own copy, otherwise the name of the first node is verified against the others
+	dialConfig.TLSClientConfig = c.TlsConfig.Clone()
+	return c.TlsDialer.DialTLS(connectionURL, dialConfig)
 }
 
 func CreateBasicRabbitConnector() *BasicRabbitConnector {
 	return &BasicRabbitConnector{
 		BasicRabbitDialer: &BasicRabbitDialer{},
+		Config:            defaultConfig(),
 	}
 }
 
@@ -130,13 +156,17 @@ func CreateTlsRabbitConnector() *TlsRabbitConnector {
 		CertPoolMaker: &X509CertPoolMaker{},
 		KeyLoader:     &X509KeyPairLoader{},
 		TlsDialer:     &X509TlsDialer{},
+		Config:        defaultConfig(),
 	}
 }
 
-func CreateConnector(connectionURL string) RabbitConnector {
-	if strings.HasPrefix(connectionURL, "amqps") {
-		return CreateTlsRabbitConnector()
-	} else {
-		return CreateBasicRabbitConnector()
+// CreateConnector creates TLS connector when any of the URLs uses amqps scheme,
+// TLS connector connects to amqp URLs without TLS
+func CreateConnector(connectionURLs ...string) RabbitConnector {
+	for _, connectionURL := range connectionURLs {
+		if strings.HasPrefix(connectionURL, "amqps") {
+			return CreateTlsRabbitConnector()
+		}
 	}
+	return CreateBasicRabbitConnector()
 }
@@ -0,0 +1,228 @@
+package connector
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
+)
+
+// CertificateWatcher connector which reports changes of its certificate files,
+// connections should be recreated to use rotated certificates
+type CertificateWatcher interface {
+	CertificatesChanged() <-chan struct{}
+}
+
+const (
+	// CertificateExpiryWarning time before client certificate expiry when warning is logged
+	CertificateExpiryWarning = 30 * 24 * time.Hour
+)
+
+// CreateConnectorWithTLS creates connector with TLS settings of the mapping entry,
+// settings are used only for amqps connections. Client certificate is loaded and validated on creation
+func CreateConnectorWithTLS(entry *config.TLSEntry, connectionURLs ...string) (RabbitConnector, error) {
+	rabbitConnector := CreateConnector(connectionURLs...)
+	tlsConnector, ok := rabbitConnector.(*TlsRabbitConnector)
+	if !ok {
+		return rabbitConnector, nil
+	}
+	if entry != nil {
+		if err := tlsConnector.configure(*entry); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tlsConnector.clientCertificate(); err != nil {
+		return nil, err
+	}
+	return tlsConnector, nil
+}
+
+// clientCertificate loads and validates client certificate once, it is reused by following connections
+// until certificate files change. Nil is returned when certificate files are not configured
+func (c *TlsRabbitConnector) clientCertificate() (*tls.Certificate, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.certificate != nil {
+		return c.certificate, nil
+	}
+	certFilePath := fileOrEnv(c.CertFile, config.CertFile)
+	keyFilePath := fileOrEnv(c.KeyFile, config.KeyFile)
+	if certFilePath == "" && keyFilePath == "" {
+		return nil, nil
+	}
+	certificate, err := c.KeyLoader.LoadKeyPair(certFilePath, keyFilePath)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":         err.Error(),
+			config.CertFile: certFilePath,
+			config.KeyFile:  keyFilePath}).Error("Error loading client certificates")
+		return nil, fmt.Errorf("could not load client certificate %s with key %s: %s", certFilePath, keyFilePath, err)
+	}
+	leaf, err := validateCertificate(certificate, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("invalid client certificate %s: %s", certFilePath, err)
+	}
+	if time.Until(leaf.NotAfter) < CertificateExpiryWarning {
+		log.WithFields(log.Fields{
+			config.CertFile: certFilePath,
+			"expiry":        leaf.NotAfter}).Warn("Client certificate expires soon")
+	}
+	log.WithFields(log.Fields{
+		config.CertFile: certFilePath,
+		"subject":       leaf.Subject.String(),
+		"expiry":        leaf.NotAfter}).Info("Loaded client certificate")
+	c.certificate = &certificate
+	return c.certificate, nil
+}
+
+// resetCertificate drops loaded client certificate, so the next connection loads rotated one
+func (c *TlsRabbitConnector) resetCertificate() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.certificate = nil
+}
+
+// validateCertificate checks that certificate chain is present and the certificate is valid at the time.
+// Private key is matched with certificate public key when the key pair is loaded
+func validateCertificate(certificate tls.Certificate, now time.Time) (*x509.Certificate, error) {
+	if len(certificate.Certificate) == 0 {
+		return nil, errors.New("no certificate found")
+	}
+	leaf := certificate.Leaf
+	if leaf == nil {
+		var err error
+		if leaf, err = x509.ParseCertificate(certificate.Certificate[0]); err != nil {
+			return nil, err
+		}
+	}
+	if now.Before(leaf.NotBefore) {
+		return nil, fmt.Errorf("certificate is not valid before %s", leaf.NotBefore)
+	}
+	if now.After(leaf.NotAfter) {
+		return nil, fmt.Errorf("certificate expired at %s", leaf.NotAfter)
+	}
+	return leaf, nil
+}
+
+// configure applies TLS settings of the mapping entry
+func (c *TlsRabbitConnector) configure(entry config.TLSEntry) error {
+	c.CaCertFile = entry.CACert
+	c.CertFile = entry.Cert
+	c.KeyFile = entry.Key
+	c.TlsConfig.ServerName = entry.ServerName
+	c.TlsConfig.InsecureSkipVerify = entry.InsecureSkipVerify
+	if entry.InsecureSkipVerify {
+		log.Warn("TLS certificate verification is disabled, use it only for development")
+	}
+	if entry.MinVersion != "" {
+		version, err := tlsVersion(entry.MinVersion)
+		if err != nil {
+			return err
+		}
+		c.TlsConfig.MinVersion = version
+	}
+	if entry.ReloadInterval != "" {
+		interval, err := time.ParseDuration(entry.ReloadInterval)
+		if err != nil {
+			return err
+		}
+		if interval <= 0 {
+			return fmt.Errorf("certificate reload interval has to be positive, found %s", entry.ReloadInterval)
+		}
+		c.ReloadInterval = interval
+	}
+	return nil
+}
+
+// CertificatesChanged channel notified when CA, certificate or key file changes, nil when reloading is disabled
+func (c *TlsRabbitConnector) CertificatesChanged() <-chan struct{} {
+	if c.ReloadInterval <= 0 {
+		return nil
+	}
+	if c.watcher == nil {
+		c.watcher = newCertificateWatcher(c.ReloadInterval, c.resetCertificate,
+			fileOrEnv(c.CaCertFile, config.CaCertFile),
+			fileOrEnv(c.CertFile, config.CertFile),
+			fileOrEnv(c.KeyFile, config.KeyFile))
+	}
+	return c.watcher.changed
+}
+
+func tlsVersion(version string) (uint16, error) {
+	switch version {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version %s, expected one of 1.0, 1.1, 1.2, 1.3", version)
+}
+
+// fileOrEnv returns file path set in mapping or in environment variable
+func fileOrEnv(file string, env string) string {
+	if file != "" {
+		return file
+	}
+	return os.Getenv(env)
+}
+
+// certificateWatcher polls modification time of certificate files. Polling works also with
+// Kubernetes secrets, which are replaced by swapping symlinks
+type certificateWatcher struct {
+	sync.Mutex
+	files    []string
+	modTimes map[string]time.Time
+	changed  chan struct{}
+}
+
+func newCertificateWatcher(interval time.Duration, onChange func(), files ...string) *certificateWatcher {
+	w := &certificateWatcher{modTimes: make(map[string]time.Time), changed: make(chan struct{}, 1)}
+	for _, file := range files {
+		if file != "" {
+			w.files = append(w.files, file)
+		}
+	}
+	w.check()
+	go func() {
+		for range time.Tick(interval) {
+			if w.check() {
+				log.WithField("files", w.files).Info("Certificate files changed")
+				onChange()
+				select {
+				case w.changed <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return w
+}
+
+// check updates modification times and reports if any of them changed since the last check
+func (w *certificateWatcher) check() bool {
+	w.Lock()
+	defer w.Unlock()
+	changed := false
+	for _, file := range w.files {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		if previous, ok := w.modTimes[file]; ok && !previous.Equal(info.ModTime()) {
+			changed = true
+		}
+		w.modTimes[file] = info.ModTime()
+	}
+	return changed
+}
@@ -1,9 +1,60 @@
 package consumer
 
-import "github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
+import (
+	"context"
+	"fmt"
+	"time"
 
-// Client intarface for consuming messages
+	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
+)
+
+const (
+	// AtLeastOnce messages are acknowledged after they are forwarded, failed messages are dead-lettered
+	AtLeastOnce = "at-least-once"
+	// AtMostOnce messages are acknowledged on delivery, failed messages are lost
+	AtMostOnce = "at-most-once"
+)
+
+// Client intarface for consuming messages. Context is used for pushes, it is cancelled
+// when graceful shutdown times out, so messages being forwarded finish after the stop signal
 type Client interface {
 	Name() string
-	Start(forwarder.Client, chan bool, chan bool) error
+	Start(context.Context, forwarder.Client, chan bool, chan bool) error
+}
+
+// Status consumer state reported by health check
+type Status struct {
+	Delivery          string `json:"delivery"`
+	DroppedMessages   uint64 `json:"droppedMessages"`
+	DuplicateMessages uint64 `json:"duplicateMessages"`
+	// Connection broker connection state, nil for consumers without persistent connection
+	Connection *ConnectionStatus `json:"connection,omitempty"`
+	// Offset last forwarded offset of stream consumer, nil for other consumers or before the first message
+	Offset *int64 `json:"offset,omitempty"`
+}
+
+// ConnectionStatus broker connection state
+type ConnectionStatus struct {
+	Connected     bool      `json:"connected"`
+	Blocked       bool      `json:"blocked"`
+	Failed        bool      `json:"failed"`
+	Reconnects    uint64    `json:"reconnects"`
+	LastConnected time.Time `json:"lastConnected"`
+	LastError     string    `json:"lastError,omitempty"`
+}
+
+// StatusClient consumer reporting its status
+type StatusClient interface {
+	Status() Status
+}
+
+// DeliveryMode validates delivery guarantee mode, at-least-once is the default
+func DeliveryMode(mode string) (string, error) {
+	switch mode {
+	case "":
+		return AtLeastOnce, nil
+	case AtLeastOnce, AtMostOnce:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown delivery mode %q, expected %s or %s", mode, AtLeastOnce, AtMostOnce)
 }
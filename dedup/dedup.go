@@ -0,0 +1,243 @@
+package dedup
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
+	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	// MemoryStore in-memory LRU store type
+	MemoryStore = "memory"
+	// DynamoDBStore DynamoDB table store type
+	DynamoDBStore = "DynamoDB"
+	// MessageIDKey deduplication by AMQP message id, body hash is used for messages without id
+	MessageIDKey = "messageId"
+	// BodyKey deduplication by body hash
+	BodyKey = "body"
+	// DefaultWindow time for which forwarded messages are remembered
+	DefaultWindow = time.Hour
+	// DefaultSize maximum number of messages remembered by in-memory store
+	DefaultSize = 10000
+	// KeyAttribute DynamoDB table hash key attribute
+	KeyAttribute = "id"
+	// ExpiresAtAttribute DynamoDB attribute with expiration time in epoch seconds, to be used as table TTL attribute
+	ExpiresAtAttribute = "expiresAt"
+	keySeparator       = ":"
+)
+
+// Store remembers keys of forwarded messages
+type Store interface {
+	Seen(key string) (bool, error)
+	Mark(key string, expiresAt time.Time) error
+}
+
+// Deduplicator skips messages which were already forwarded within the window
+type Deduplicator struct {
+	key             string
+	window          time.Duration
+	redeliveredOnly bool
+	store           Store
+	skipped         uint64
+}
+
+// New creates deduplicator of the named consumer from mapping entry
+func New(name string, entry config.DedupEntry, dynamoClient ...dynamodbiface.DynamoDBAPI) (*Deduplicator, error) {
+	d := &Deduplicator{key: entry.Key, window: DefaultWindow, redeliveredOnly: entry.RedeliveredOnly}
+	switch d.key {
+	case "":
+		d.key = MessageIDKey
+	case MessageIDKey, BodyKey:
+	default:
+		return nil, fmt.Errorf("unknown deduplication key %s", entry.Key)
+	}
+	if entry.Window != "" {
+		window, err := time.ParseDuration(entry.Window)
+		if err != nil {
+			return nil, err
+		}
+		if window <= 0 {
+			return nil, fmt.Errorf("deduplication window has to be positive, found %s", entry.Window)
+		}
+		d.window = window
+	}
+	switch entry.Store {
+	case "", MemoryStore:
+		size := entry.Size
+		if size <= 0 {
+			size = DefaultSize
+		}
+		d.store = newMemory(size)
+	case DynamoDBStore:
+		if entry.Table == "" {
+			return nil, fmt.Errorf("deduplication table is required for %s store", DynamoDBStore)
+		}
+		var client dynamodbiface.DynamoDBAPI
+		if len(dynamoClient) > 0 {
+			client = dynamoClient[0]
+		} else {
+			client = dynamodb.New(session.Must(session.NewSession()))
+		}
+		d.store = dynamoStore{client, entry.Table, name + keySeparator}
+	default:
+		return nil, fmt.Errorf("unknown deduplication store %s", entry.Store)
+	}
+	return d, nil
+}
+
+// Key returns deduplication key of the message
+func (d *Deduplicator) Key(message forwarder.Message) string {
+	if d == nil {
+		return ""
+	}
+	if d.key == MessageIDKey && message.MessageID != "" {
+		return message.MessageID
+	}
+	sum := sha256.Sum256([]byte(message.Body))
+	return hex.EncodeToString(sum[:])
+}
+
+// Duplicate checks if message with the key was already forwarded. Redelivered messages are always checked,
+// other messages only when store is not limited to redeliveries. Store errors are logged and message is forwarded
+func (d *Deduplicator) Duplicate(key string, redelivered bool) bool {
+	if d == nil || key == "" || (d.redeliveredOnly && !redelivered) {
+		return false
+	}
+	seen, err := d.store.Seen(key)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"key":   key,
+			"error": err.Error()}).Error("Could not check deduplication store")
+		return false
+	}
+	if seen {
+		atomic.AddUint64(&d.skipped, 1)
+	}
+	return seen
+}
+
+// Mark remembers forwarded message key for the window
+func (d *Deduplicator) Mark(key string) {
+	if d == nil || key == "" {
+		return
+	}
+	if err := d.store.Mark(key, time.Now().Add(d.window)); err != nil {
+		log.WithFields(log.Fields{
+			"key":   key,
+			"error": err.Error()}).Error("Could not update deduplication store")
+	}
+}
+
+// Skipped number of duplicates which were not forwarded
+func (d *Deduplicator) Skipped() uint64 {
+	if d == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&d.skipped)
+}
+
+// memory LRU store with expiring entries
+type memory struct {
+	sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+	now     func() time.Time
+}
+
+type memoryEntry struct {
+	key       string
+	expiresAt time.Time
+}
+
+func newMemory(size int) *memory {
+	return &memory{size: size, order: list.New(), entries: make(map[string]*list.Element), now: time.Now}
+}
+
+func (m *memory) Seen(key string) (bool, error) {
+	m.Lock()
+	defer m.Unlock()
+	element, ok := m.entries[key]
+	if !ok {
+		return false, nil
+	}
+	if m.now().After(element.Value.(*memoryEntry).expiresAt) {
+		m.order.Remove(element)
+		delete(m.entries, key)
+		return false, nil
+	}
+	return true, nil
+}
+
+func (m *memory) Mark(key string, expiresAt time.Time) error {
+	m.Lock()
+	defer m.Unlock()
+	if element, ok := m.entries[key]; ok {
+		element.Value.(*memoryEntry).expiresAt = expiresAt
+		m.order.MoveToFront(element)
+		return nil
+	}
+	m.entries[key] = m.order.PushFront(&memoryEntry{key, expiresAt})
+	if m.order.Len() > m.size {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(*memoryEntry).key)
+	}
+	return nil
+}
+
+// dynamoStore keeps keys in DynamoDB table shared by forwarder instances. Keys are prefixed
+// with consumer name, so mappings sharing the table do not skip each other's messages
+type dynamoStore struct {
+	client dynamodbiface.DynamoDBAPI
+	table  string
+	prefix string
+}
+
+func (s dynamoStore) Seen(key string) (bool, error) {
+	resp, err := s.client.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(s.table),
+		Key:            map[string]*dynamodb.AttributeValue{KeyAttribute: {S: aws.String(s.prefix + key)}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return false, err
+	}
+	if resp.Item == nil {
+		return false, nil
+	}
+	// TTL deletion is delayed, expired items may still be returned
+	if expiresAt, ok := resp.Item[ExpiresAtAttribute]; ok && expiresAt.N != nil {
+		seconds, err := strconv.ParseInt(*expiresAt.N, 10, 64)
+		if err != nil {
+			return false, err
+		}
+		return time.Now().Unix() < seconds, nil
+	}
+	return true, nil
+}
+
+func (s dynamoStore) Mark(key string, expiresAt time.Time) error {
+	_, err := s.client.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			KeyAttribute:       {S: aws.String(s.prefix + key)},
+			ExpiresAtAttribute: {N: aws.String(strconv.FormatInt(expiresAt.Unix(), 10))},
+		},
+	})
+	return err
+}
@@ -0,0 +1,158 @@
+package dedup
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
+	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+func TestNew(t *testing.T) {
+	scenarios := []struct {
+		name  string
+		entry config.DedupEntry
+		valid bool
+	}{
+		{name: "defaults", entry: config.DedupEntry{}, valid: true},
+		{name: "body key with window", entry: config.DedupEntry{Key: BodyKey, Window: "10m"}, valid: true},
+		{name: "unknown key", entry: config.DedupEntry{Key: "routingKey"}, valid: false},
+		{name: "invalid window", entry: config.DedupEntry{Window: "hour"}, valid: false},
+		{name: "negative window", entry: config.DedupEntry{Window: "-1m"}, valid: false},
+		{name: "dynamodb without table", entry: config.DedupEntry{Store: DynamoDBStore}, valid: false},
+		{name: "dynamodb", entry: config.DedupEntry{Store: DynamoDBStore, Table: "dedup"}, valid: true},
+		{name: "unknown store", entry: config.DedupEntry{Store: "Redis"}, valid: false},
+	}
+	for _, scenario := range scenarios {
+		t.Log("Scenario name: ", scenario.name)
+		_, err := New("rabbit-to-sns", scenario.entry, &mockDynamoDB{})
+		if scenario.valid && err != nil {
+			t.Errorf("deduplicator should be created, got error: %s", err.Error())
+		}
+		if !scenario.valid && err == nil {
+			t.Errorf("deduplicator should not be created")
+		}
+	}
+}
+
+func TestKey(t *testing.T) {
+	byID, _ := New("rabbit-to-sns", config.DedupEntry{})
+	byBody, _ := New("rabbit-to-sns", config.DedupEntry{Key: BodyKey})
+	withID := forwarder.Message{MessageID: "id-1", Body: "abc"}
+	withoutID := forwarder.Message{Body: "abc"}
+	if key := byID.Key(withID); key != "id-1" {
+		t.Errorf("wrong key, expected:%s, found:%s", "id-1", key)
+	}
+	if byID.Key(withoutID) != byBody.Key(withID) {
+		t.Errorf("message without id should be deduplicated by body hash")
+	}
+	if byBody.Key(withID) == byBody.Key(forwarder.Message{Body: "abd"}) {
+		t.Errorf("different bodies should have different keys")
+	}
+	var nilDedup *Deduplicator
+	if nilDedup.Key(withID) != "" || nilDedup.Duplicate("id-1", true) {
+		t.Errorf("nil deduplicator should not detect duplicates")
+	}
+}
+
+func TestDuplicate(t *testing.T) {
+	d, _ := New("rabbit-to-sns", config.DedupEntry{})
+	if d.Duplicate("id-1", false) {
+		t.Errorf("new message should not be duplicate")
+	}
+	d.Mark("id-1")
+	if !d.Duplicate("id-1", true) {
+		t.Errorf("forwarded message should be duplicate")
+	}
+	if d.Skipped() != 1 {
+		t.Errorf("wrong number of skipped messages, expected:%d, found:%d", 1, d.Skipped())
+	}
+
+	redeliveredOnly, _ := New("rabbit-to-sns", config.DedupEntry{RedeliveredOnly: true})
+	redeliveredOnly.Mark("id-1")
+	if redeliveredOnly.Duplicate("id-1", false) {
+		t.Errorf("first delivery should not be checked")
+	}
+	if !redeliveredOnly.Duplicate("id-1", true) {
+		t.Errorf("redelivered message should be checked")
+	}
+}
+
+func TestMemory(t *testing.T) {
+	now := time.Now()
+	m := newMemory(2)
+	m.now = func() time.Time { return now }
+	m.Mark("a", now.Add(time.Minute))
+	m.Mark("b", now.Add(time.Minute))
+	m.Mark("a", now.Add(time.Minute))
+	m.Mark("c", now.Add(time.Minute))
+	if seen, _ := m.Seen("b"); seen {
+		t.Errorf("least recently used key should be evicted")
+	}
+	if seen, _ := m.Seen("a"); !seen {
+		t.Errorf("recently used key should be remembered")
+	}
+	m.now = func() time.Time { return now.Add(2 * time.Minute) }
+	if seen, _ := m.Seen("c"); seen {
+		t.Errorf("expired key should not be remembered")
+	}
+	if m.order.Len() != 1 {
+		t.Errorf("expired key should be removed, found %d keys", m.order.Len())
+	}
+}
+
+func TestDynamoStore(t *testing.T) {
+	mock := &mockDynamoDB{items: make(map[string]map[string]*dynamodb.AttributeValue)}
+	d, err := New("rabbit-to-sns", config.DedupEntry{Store: DynamoDBStore, Table: "dedup"}, mock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Duplicate("id-1", true) {
+		t.Errorf("new message should not be duplicate")
+	}
+	d.Mark("id-1")
+	if !d.Duplicate("id-1", true) {
+		t.Errorf("forwarded message should be duplicate")
+	}
+	mock.items["rabbit-to-sns:id-1"][ExpiresAtAttribute].N = aws.String(strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10))
+	if d.Duplicate("id-1", true) {
+		t.Errorf("expired item should not be duplicate")
+	}
+}
+
+func TestDynamoStoreSharedByMappings(t *testing.T) {
+	mock := &mockDynamoDB{items: make(map[string]map[string]*dynamodb.AttributeValue)}
+	entry := config.DedupEntry{Store: DynamoDBStore, Table: "dedup"}
+	toSNS, _ := New("rabbit-to-sns", entry, mock)
+	toSQS, _ := New("rabbit-to-sqs", entry, mock)
+
+	toSNS.Mark("id-1")
+	if toSQS.Duplicate("id-1", true) {
+		t.Errorf("message forwarded by other mapping should not be duplicate")
+	}
+	toSQS.Mark("id-1")
+	if !toSNS.Duplicate("id-1", true) || !toSQS.Duplicate("id-1", true) {
+		t.Errorf("message forwarded by both mappings should be duplicate for each of them")
+	}
+	if len(mock.items) != 2 {
+		t.Errorf("each mapping should store own item, found %d items", len(mock.items))
+	}
+}
+
+type mockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	items map[string]map[string]*dynamodb.AttributeValue
+}
+
+func (m *mockDynamoDB) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return &dynamodb.GetItemOutput{Item: m.items[*input.Key[KeyAttribute].S]}, nil
+}
+
+func (m *mockDynamoDB) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	m.items[*input.Item[KeyAttribute].S] = input.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
@@ -0,0 +1,183 @@
+package dynamodb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
+	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Type forwarder type
+	Type = "DynamoDB"
+	// NotAnObjectError message error message
+	NotAnObjectError = "message is not a JSON object"
+	// duplicateCondition skips writes of already written message
+	duplicateCondition = "attribute_not_exists(#messageId) OR #messageId <> :messageId"
+)
+
+// Forwarder forwarding client
+type Forwarder struct {
+	name               string
+	dynamoDBClient     dynamodbiface.DynamoDBAPI
+	table              string
+	keys               map[string]string
+	ttlAttribute       string
+	ttl                time.Duration
+	messageIDAttribute string
+	timeout            time.Duration
+}
+
+// CreateForwarder creates instance of forwarder
+func CreateForwarder(entry config.AmazonEntry, dynamoDBClient ...dynamodbiface.DynamoDBAPI) forwarder.Client {
+	var client dynamodbiface.DynamoDBAPI
+	if len(dynamoDBClient) > 0 {
+		client = dynamoDBClient[0]
+	} else {
+		client = dynamodb.New(session.Must(session.NewSession()))
+	}
+	for attribute, field := range entry.Keys {
+		if !forwarder.ValidField(field) {
+			log.WithFields(log.Fields{
+				"forwarderName": entry.Name,
+				"attribute":     attribute,
+				"field":         field}).Fatal("Unknown key attribute field")
+		}
+	}
+	var ttl time.Duration
+	if entry.TTLAttribute != "" {
+		var err error
+		if ttl, err = time.ParseDuration(entry.TTL); err != nil {
+			log.WithFields(log.Fields{
+				"forwarderName": entry.Name,
+				"error":         err.Error()}).Fatal("Could not parse TTL")
+		}
+	}
+	timeout, err := forwarder.Timeout(entry.Timeout, forwarder.DefaultTimeout)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"forwarderName": entry.Name,
+			"error":         err.Error()}).Fatal("Invalid push timeout")
+	}
+	forwarder := Forwarder{entry.Name, client, entry.Target, entry.Keys, entry.TTLAttribute, ttl, entry.MessageIDAttribute, timeout}
+	log.WithField("forwarderName", forwarder.Name()).Info("Created forwarder")
+	return forwarder
+}
+
+// Name forwarder name
+func (f Forwarder) Name() string {
+	return f.name
+}
+
+// Push puts JSON message as table item, push is cancelled when context is done or timeout passes
+func (f Forwarder) Push(ctx context.Context, message forwarder.Message) error {
+	if message.Body == "" {
+		return errors.New(forwarder.EmptyMessageError)
+	}
+	params, err := f.putItemInput(message)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"forwarderName": f.Name(),
+			"error":         err.Error()}).Error("Could not create item")
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+	if _, err = f.dynamoDBClient.PutItemWithContext(ctx, params); err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			log.WithFields(log.Fields{
+				"forwarderName": f.Name(),
+				"messageID":     message.MessageID}).Info("Message already forwarded")
+			return nil
+		}
+		log.WithFields(log.Fields{
+			"forwarderName": f.Name(),
+			"error":         err.Error()}).Error("Could not forward message")
+		return err
+	}
+	log.WithField("forwarderName", f.Name()).Info("Forward succeeded")
+	return nil
+}
+
+// keyValue key attribute of the field, JSON numbers of body fields are stored as numbers
+// to match number keys of the table, other fields as strings
+func keyValue(message forwarder.Message, field string, value string) *dynamodb.AttributeValue {
+	if strings.HasPrefix(field, forwarder.BodyPrefix) {
+		if raw, ok := message.BodyValue(strings.TrimPrefix(field, forwarder.BodyPrefix)); ok {
+			if _, ok := raw.(json.Number); ok {
+				return &dynamodb.AttributeValue{N: aws.String(value)}
+			}
+		}
+	}
+	return &dynamodb.AttributeValue{S: aws.String(value)}
+}
+
+// numbers replaces JSON numbers of decoded document with DynamoDB numbers, so they are stored
+// as N with exact digits instead of being rounded through float64
+func numbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		return dynamodbattribute.Number(v)
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = numbers(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = numbers(item)
+		}
+	}
+	return value
+}
+
+func (f Forwarder) putItemInput(message forwarder.Message) (*dynamodb.PutItemInput, error) {
+	var doc map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(message.Body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil || doc == nil || decoder.More() {
+		return nil, errors.New(NotAnObjectError)
+	}
+	item, err := dynamodbattribute.MarshalMap(numbers(doc))
+	if err != nil {
+		return nil, err
+	}
+	for attribute, field := range f.keys {
+		value, ok := message.Field(field)
+		if !ok || value == "" {
+			return nil, fmt.Errorf("key attribute %s is empty", attribute)
+		}
+		item[attribute] = keyValue(message, field, value)
+	}
+	if f.ttlAttribute != "" {
+		timestamp := message.Timestamp
+		if timestamp.IsZero() {
+			timestamp = time.Now()
+		}
+		expiresAt := strconv.FormatInt(timestamp.Add(f.ttl).Unix(), 10)
+		item[f.ttlAttribute] = &dynamodb.AttributeValue{N: aws.String(expiresAt)}
+	}
+	params := &dynamodb.PutItemInput{
+		TableName: aws.String(f.table),
+		Item:      item,
+	}
+	if f.messageIDAttribute != "" && message.MessageID != "" {
+		item[f.messageIDAttribute] = &dynamodb.AttributeValue{S: aws.String(message.MessageID)}
+		params.ConditionExpression = aws.String(duplicateCondition)
+		params.ExpressionAttributeNames = map[string]*string{"#messageId": aws.String(f.messageIDAttribute)}
+		params.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{":messageId": {S: aws.String(message.MessageID)}}
+	}
+	return params, nil
+}
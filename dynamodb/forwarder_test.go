@@ -0,0 +1,152 @@
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
+	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	badRequest = `{"bad":"request"}`
+	tableName  = "table1"
+)
+
+func TestCreateForwarder(t *testing.T) {
+	entry := config.AmazonEntry{Type: "DynamoDB",
+		Name:   "dynamodb-test",
+		Target: tableName,
+	}
+	forwarder := CreateForwarder(entry)
+	if forwarder.Name() != entry.Name {
+		t.Errorf("wrong forwarder name, expected:%s, found: %s", entry.Name, forwarder.Name())
+	}
+}
+
+func TestPushMessage(t *testing.T) {
+	timestamp := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+	message := forwarder.Message{Body: `{"user":{"id":"42"},"name":"john"}`, RoutingKey: "user.created", MessageID: "message1", Timestamp: timestamp}
+	scenarios := []struct {
+		name      string
+		entry     config.AmazonEntry
+		message   forwarder.Message
+		duplicate bool
+		check     func(input *dynamodb.PutItemInput) bool
+		err       error
+	}{
+		{
+			name:    "empty message",
+			message: forwarder.Message{Body: ""},
+			err:     errors.New(forwarder.EmptyMessageError),
+		},
+		{
+			name:    "not an object",
+			message: forwarder.Message{Body: "abc"},
+			err:     errors.New(NotAnObjectError),
+		},
+		{
+			name:    "missing key",
+			entry:   config.AmazonEntry{Keys: map[string]string{"pk": "body.id"}},
+			message: message,
+			err:     errors.New("key attribute pk is empty"),
+		},
+		{
+			name:    "bad request",
+			message: forwarder.Message{Body: badRequest},
+			err:     errors.New("Bad request"),
+		},
+		{
+			name:    "item",
+			message: message,
+			check: func(input *dynamodb.PutItemInput) bool {
+				return *input.Item["name"].S == "john" && *input.Item["user"].M["id"].S == "42" && input.ConditionExpression == nil
+			},
+		},
+		{
+			name:    "keys and ttl",
+			entry:   config.AmazonEntry{Keys: map[string]string{"pk": "body.user.id", "sk": "routingKey"}, TTLAttribute: "expiresAt", TTL: "24h"},
+			message: message,
+			check: func(input *dynamodb.PutItemInput) bool {
+				return *input.Item["pk"].S == "42" && *input.Item["sk"].S == "user.created" && *input.Item["expiresAt"].N == "1506945600"
+			},
+		},
+		{
+			name:    "number key",
+			entry:   config.AmazonEntry{Keys: map[string]string{"pk": "body.order.id", "sk": "body.order.placedAt"}},
+			message: forwarder.Message{Body: `{"order":{"id":12345678901234567,"placedAt":"2017-10-01"}}`},
+			check: func(input *dynamodb.PutItemInput) bool {
+				return input.Item["pk"].S == nil && *input.Item["pk"].N == "12345678901234567" && *input.Item["sk"].S == "2017-10-01"
+			},
+		},
+		{
+			name:    "large number",
+			message: forwarder.Message{Body: `{"amount":12345678901234567891,"items":[{"price":0.1}]}`},
+			check: func(input *dynamodb.PutItemInput) bool {
+				return *input.Item["amount"].N == "12345678901234567891" && *input.Item["items"].L[0].M["price"].N == "0.1"
+			},
+		},
+		{
+			name:    "conditional write",
+			entry:   config.AmazonEntry{MessageIDAttribute: "messageId"},
+			message: message,
+			check: func(input *dynamodb.PutItemInput) bool {
+				return *input.Item["messageId"].S == "message1" && *input.ConditionExpression == duplicateCondition &&
+					*input.ExpressionAttributeValues[":messageId"].S == "message1"
+			},
+		},
+		{
+			name:      "duplicate message",
+			entry:     config.AmazonEntry{MessageIDAttribute: "messageId"},
+			message:   message,
+			duplicate: true,
+		},
+	}
+	for _, scenario := range scenarios {
+		t.Log("Scenario name: ", scenario.name)
+		scenario.entry.Type, scenario.entry.Name, scenario.entry.Target = "DynamoDB", "dynamodb-test", tableName
+		mock := &mockAmazonDynamoDB{duplicate: scenario.duplicate}
+		forwarder := CreateForwarder(scenario.entry, mock).(Forwarder)
+		err := forwarder.Push(context.Background(), scenario.message)
+		if scenario.err != nil {
+			if err == nil || err.Error() != scenario.err.Error() {
+				t.Errorf("Wrong error, expecting:%v, got:%v", scenario.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Error should not occur. Error: %s", err.Error())
+			continue
+		}
+		if scenario.check != nil && !scenario.check(mock.input) {
+			t.Errorf("wrong item, got:%s", mock.input)
+		}
+	}
+}
+
+type mockAmazonDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	input     *dynamodb.PutItemInput
+	duplicate bool
+}
+
+func (m *mockAmazonDynamoDB) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	if *input.TableName != tableName {
+		return nil, errors.New("Wrong table name")
+	}
+	if input.Item["bad"] != nil {
+		return nil, errors.New("Bad request")
+	}
+	if m.duplicate {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
+	m.input = input
+	return &dynamodb.PutItemOutput{}, nil
+}
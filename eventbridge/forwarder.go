@@ -0,0 +1,212 @@
+package eventbridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
+	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/eventbridge/eventbridgeiface"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Type forwarder type
+	Type = "EventBridge"
+	// MaxBatchSize maximum number of entries in PutEvents request
+	MaxBatchSize = 10
+	// MaxBatchBytes maximum size of PutEvents request
+	MaxBatchBytes = 256 * 1024
+	// DefaultBatchInterval time after which incomplete batch is sent
+	DefaultBatchInterval = 100 * time.Millisecond
+	// DefaultSource event source used when none is configured
+	DefaultSource = "rabbitmq"
+	// NotAnObjectError detail error message
+	NotAnObjectError = "message is not a JSON object"
+	// EmptyDetailTypeError detail type error message
+	EmptyDetailTypeError = "event detail type is empty"
+	// timestamp is counted as 14 bytes of entry size
+	timestampSize = 14
+)
+
+// Forwarder forwarding client
+type Forwarder struct {
+	name              string
+	eventBridgeClient eventbridgeiface.EventBridgeAPI
+	eventBus          string
+	source            string
+	sourceField       string
+	detailType        string
+	detailTypeField   string
+	batchSize         int
+	batchInterval     time.Duration
+	timeout           time.Duration
+}
+
+// CreateForwarder creates instance of forwarder
+func CreateForwarder(entry config.AmazonEntry, eventBridgeClient ...eventbridgeiface.EventBridgeAPI) forwarder.Client {
+	var client eventbridgeiface.EventBridgeAPI
+	if len(eventBridgeClient) > 0 {
+		client = eventBridgeClient[0]
+	} else {
+		client = eventbridge.New(session.Must(session.NewSession()))
+	}
+	batchSize, batchInterval, err := forwarder.BatchSettings(entry.Batch, MaxBatchSize, DefaultBatchInterval)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"forwarderName": entry.Name,
+			"error":         err.Error()}).Fatal("Could not parse batch interval")
+	}
+	source, detailTypeField := entry.Source, entry.DetailTypeField
+	if source == "" {
+		source = DefaultSource
+	}
+	if entry.DetailType == "" && detailTypeField == "" {
+		detailTypeField = forwarder.RoutingKeyField
+	}
+	for _, field := range []string{entry.SourceField, detailTypeField} {
+		if field != "" && !forwarder.ValidField(field) {
+			log.WithFields(log.Fields{
+				"forwarderName": entry.Name,
+				"field":         field}).Fatal("Unknown message field")
+		}
+	}
+	timeout, err := forwarder.Timeout(entry.Timeout, forwarder.DefaultTimeout)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"forwarderName": entry.Name,
+			"error":         err.Error()}).Fatal("Invalid push timeout")
+	}
+	forwarder := Forwarder{
+		name:              entry.Name,
+		eventBridgeClient: client,
+		eventBus:          entry.Target,
+		source:            source,
+		sourceField:       entry.SourceField,
+		detailType:        entry.DetailType,
+		detailTypeField:   detailTypeField,
+		batchSize:         batchSize,
+		batchInterval:     batchInterval,
+		timeout:           timeout,
+	}
+	log.WithField("forwarderName", forwarder.Name()).Info("Created forwarder")
+	return forwarder
+}
+
+// Name forwarder name
+func (f Forwarder) Name() string {
+	return f.name
+}
+
+// BatchSize maximum number of messages in a batch
+func (f Forwarder) BatchSize() int {
+	return f.batchSize
+}
+
+// BatchInterval time after which incomplete batch is forwarded
+func (f Forwarder) BatchInterval() time.Duration {
+	return f.batchInterval
+}
+
+// Push pushes message to forwarding infrastructure
+func (f Forwarder) Push(ctx context.Context, message forwarder.Message) error {
+	return f.PushBatch(ctx, []forwarder.Message{message})[0]
+}
+
+// PushBatch puts messages as events on the event bus, every request is cancelled
+// when context is done or timeout passes
+func (f Forwarder) PushBatch(ctx context.Context, messages []forwarder.Message) []error {
+	errs := make([]error, len(messages))
+	var entries []*eventbridge.PutEventsRequestEntry
+	var indexes []int
+	size := 0
+	for i, message := range messages {
+		entry, err := f.createEntry(message)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		entrySize := timestampSize + len(*entry.Source) + len(*entry.DetailType) + len(*entry.Detail)
+		if len(entries) == MaxBatchSize || (len(entries) > 0 && size+entrySize > MaxBatchBytes) {
+			f.putEvents(ctx, entries, indexes, errs)
+			entries, indexes, size = nil, nil, 0
+		}
+		entries = append(entries, entry)
+		indexes = append(indexes, i)
+		size += entrySize
+	}
+	if len(entries) > 0 {
+		f.putEvents(ctx, entries, indexes, errs)
+	}
+	return errs
+}
+
+func (f Forwarder) createEntry(message forwarder.Message) (*eventbridge.PutEventsRequestEntry, error) {
+	if message.Body == "" {
+		return nil, errors.New(forwarder.EmptyMessageError)
+	}
+	if !strings.HasPrefix(strings.TrimSpace(message.Body), "{") || !json.Valid([]byte(message.Body)) {
+		return nil, errors.New(NotAnObjectError)
+	}
+	source := f.field(message, f.sourceField, f.source)
+	detailType := f.field(message, f.detailTypeField, f.detailType)
+	if detailType == "" {
+		return nil, errors.New(EmptyDetailTypeError)
+	}
+	entry := &eventbridge.PutEventsRequestEntry{
+		Detail:     aws.String(message.Body),
+		DetailType: aws.String(detailType),
+		Source:     aws.String(source),
+	}
+	if f.eventBus != "" {
+		entry.EventBusName = aws.String(f.eventBus)
+	}
+	if !message.Timestamp.IsZero() {
+		entry.Time = aws.Time(message.Timestamp)
+	}
+	return entry, nil
+}
+
+// field message field value or static value if the field is not configured or empty
+func (f Forwarder) field(message forwarder.Message, name string, static string) string {
+	if name != "" {
+		if value, _ := message.Field(name); value != "" {
+			return value
+		}
+	}
+	return static
+}
+
+// putEvents sends entries and stores errors under message indexes
+func (f Forwarder) putEvents(ctx context.Context, entries []*eventbridge.PutEventsRequestEntry, indexes []int, errs []error) {
+	params := &eventbridge.PutEventsInput{
+		Entries: entries,
+	}
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+	resp, err := f.eventBridgeClient.PutEventsWithContext(ctx, params)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"forwarderName": f.Name(),
+			"error":         err.Error()}).Error("Could not forward messages")
+		for _, i := range indexes {
+			errs[i] = err
+		}
+		return
+	}
+	for j, entry := range resp.Entries {
+		if j < len(indexes) && entry.ErrorCode != nil {
+			errs[indexes[j]] = errors.New(aws.StringValue(entry.ErrorCode) + ": " + aws.StringValue(entry.ErrorMessage))
+		}
+	}
+	log.WithFields(log.Fields{
+		"forwarderName": f.Name(),
+		"events":        len(entries),
+		"failedEvents":  aws.Int64Value(resp.FailedEntryCount)}).Info("Forward succeeded")
+}
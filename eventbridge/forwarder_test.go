@@ -0,0 +1,184 @@
+package eventbridge
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
+	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/eventbridge/eventbridgeiface"
+)
+
+const (
+	badRequest  = `{"bad":"request"}`
+	failedEvent = `{"failed":true}`
+	eventBus    = "bus1"
+)
+
+func TestCreateForwarder(t *testing.T) {
+	entry := config.AmazonEntry{Type: "EventBridge",
+		Name:   "eventbridge-test",
+		Target: eventBus,
+		Batch:  &config.BatchEntry{Size: 5},
+	}
+	forwarder := CreateForwarder(entry).(Forwarder)
+	if forwarder.Name() != entry.Name {
+		t.Errorf("wrong forwarder name, expected:%s, found: %s", entry.Name, forwarder.Name())
+	}
+	if forwarder.BatchSize() != 5 {
+		t.Errorf("wrong batch size, expected:%d, found: %d", 5, forwarder.BatchSize())
+	}
+	if forwarder.BatchInterval() != DefaultBatchInterval {
+		t.Errorf("wrong batch interval, expected:%s, found: %s", DefaultBatchInterval, forwarder.BatchInterval())
+	}
+}
+
+func TestPushMessage(t *testing.T) {
+	timestamp := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+	scenarios := []struct {
+		name       string
+		entry      config.AmazonEntry
+		message    forwarder.Message
+		source     string
+		detailType string
+		err        error
+	}{
+		{
+			name:    "empty message",
+			entry:   config.AmazonEntry{},
+			message: forwarder.Message{Body: "", RoutingKey: "user.created"},
+			err:     errors.New(forwarder.EmptyMessageError),
+		},
+		{
+			name:    "not an object",
+			entry:   config.AmazonEntry{},
+			message: forwarder.Message{Body: "[1,2]", RoutingKey: "user.created"},
+			err:     errors.New(NotAnObjectError),
+		},
+		{
+			name:    "empty detail type",
+			entry:   config.AmazonEntry{},
+			message: forwarder.Message{Body: "{}"},
+			err:     errors.New(EmptyDetailTypeError),
+		},
+		{
+			name:    "bad request",
+			entry:   config.AmazonEntry{},
+			message: forwarder.Message{Body: badRequest, RoutingKey: "user.created"},
+			err:     errors.New("Bad request"),
+		},
+		{
+			name:    "failed entry",
+			entry:   config.AmazonEntry{},
+			message: forwarder.Message{Body: failedEvent, RoutingKey: "user.created"},
+			err:     errors.New("InternalFailure: Failed"),
+		},
+		{
+			name:       "defaults",
+			entry:      config.AmazonEntry{},
+			message:    forwarder.Message{Body: "{}", RoutingKey: "user.created", Timestamp: timestamp},
+			source:     DefaultSource,
+			detailType: "user.created",
+		},
+		{
+			name:       "static source and detail type",
+			entry:      config.AmazonEntry{Source: "com.airhelp.crm", DetailType: "User"},
+			message:    forwarder.Message{Body: "{}", RoutingKey: "user.created"},
+			source:     "com.airhelp.crm",
+			detailType: "User",
+		},
+		{
+			name:       "source and detail type from headers",
+			entry:      config.AmazonEntry{Source: "com.airhelp", SourceField: "headers.source", DetailTypeField: "headers.type"},
+			message:    forwarder.Message{Body: "{}", Headers: map[string]interface{}{"source": "com.airhelp.crm", "type": "UserCreated"}},
+			source:     "com.airhelp.crm",
+			detailType: "UserCreated",
+		},
+		{
+			name:       "missing source header",
+			entry:      config.AmazonEntry{Source: "com.airhelp", SourceField: "headers.source"},
+			message:    forwarder.Message{Body: "{}", RoutingKey: "user.created"},
+			source:     "com.airhelp",
+			detailType: "user.created",
+		},
+	}
+	for _, scenario := range scenarios {
+		t.Log("Scenario name: ", scenario.name)
+		scenario.entry.Type, scenario.entry.Name, scenario.entry.Target = "EventBridge", "eventbridge-test", eventBus
+		mock := &mockAmazonEventBridge{}
+		forwarder := CreateForwarder(scenario.entry, mock).(Forwarder)
+		err := forwarder.Push(context.Background(), scenario.message)
+		if scenario.err != nil {
+			if err == nil || err.Error() != scenario.err.Error() {
+				t.Errorf("Wrong error, expecting:%v, got:%v", scenario.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Error should not occur. Error: %s", err.Error())
+			continue
+		}
+		entry := mock.requests[0][0]
+		if *entry.Source != scenario.source || *entry.DetailType != scenario.detailType {
+			t.Errorf("wrong event, expected source:%s, detail type:%s, got:%s", scenario.source, scenario.detailType, entry)
+		}
+		if !scenario.message.Timestamp.IsZero() && !entry.Time.Equal(timestamp) {
+			t.Errorf("wrong event time, expected:%s, got:%s", timestamp, entry.Time)
+		}
+	}
+}
+
+func TestPushBatch(t *testing.T) {
+	entry := config.AmazonEntry{Type: "EventBridge",
+		Name:   "eventbridge-test",
+		Target: eventBus,
+	}
+	mock := &mockAmazonEventBridge{}
+	client := CreateForwarder(entry, mock).(Forwarder)
+	var messages []forwarder.Message
+	for i := 0; i < MaxBatchSize+2; i++ {
+		messages = append(messages, forwarder.Message{Body: "{}", RoutingKey: "user.created"})
+	}
+	messages[3].Body = failedEvent
+	messages[MaxBatchSize].Body = ""
+	errs := client.PushBatch(context.Background(), messages)
+	for i, err := range errs {
+		shouldFail := i == 3 || i == MaxBatchSize
+		if shouldFail != (err != nil) {
+			t.Errorf("wrong result of message %d, error: %v", i, err)
+		}
+	}
+	if len(mock.requests) != 2 || len(mock.requests[0]) != MaxBatchSize || len(mock.requests[1]) != 1 {
+		t.Errorf("messages should be split into batches of at most %d entries", MaxBatchSize)
+	}
+}
+
+type mockAmazonEventBridge struct {
+	eventbridgeiface.EventBridgeAPI
+	requests [][]*eventbridge.PutEventsRequestEntry
+}
+
+func (m *mockAmazonEventBridge) PutEventsWithContext(ctx aws.Context, input *eventbridge.PutEventsInput, opts ...request.Option) (*eventbridge.PutEventsOutput, error) {
+	if *input.Entries[0].Detail == badRequest {
+		return nil, errors.New("Bad request")
+	}
+	m.requests = append(m.requests, input.Entries)
+	resp := &eventbridge.PutEventsOutput{FailedEntryCount: aws.Int64(0)}
+	for _, entry := range input.Entries {
+		if *entry.EventBusName != eventBus {
+			return nil, errors.New("Wrong event bus name")
+		}
+		result := &eventbridge.PutEventsResultEntry{EventId: aws.String("id")}
+		if *entry.Detail == failedEvent {
+			result = &eventbridge.PutEventsResultEntry{ErrorCode: aws.String("InternalFailure"), ErrorMessage: aws.String("Failed")}
+			resp.FailedEntryCount = aws.Int64(*resp.FailedEntryCount + 1)
+		}
+		resp.Entries = append(resp.Entries, result)
+	}
+	return resp, nil
+}
@@ -0,0 +1,93 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"sync/atomic"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
+	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
+)
+
+// Filter decides which messages should be forwarded
+type Filter struct {
+	conditions []condition
+	dropped    uint64
+}
+
+type condition struct {
+	field   string
+	equals  *string
+	in      []string
+	pattern *regexp.Regexp
+	exists  *bool
+	not     bool
+}
+
+// New creates filter from mapping entries
+func New(entries []config.FilterEntry) (*Filter, error) {
+	f := &Filter{}
+	for _, entry := range entries {
+		if !forwarder.ValidField(entry.Field) {
+			return nil, fmt.Errorf("unknown filter field %s", entry.Field)
+		}
+		cond := condition{field: entry.Field, equals: entry.Equals, in: entry.In, exists: entry.Exists, not: entry.Not}
+		if entry.Pattern != "" {
+			pattern, err := regexp.Compile(entry.Pattern)
+			if err != nil {
+				return nil, err
+			}
+			cond.pattern = pattern
+		}
+		f.conditions = append(f.conditions, cond)
+	}
+	return f, nil
+}
+
+// Match checks if message matches every condition. Non matching messages are counted as dropped
+func (f *Filter) Match(message forwarder.Message) bool {
+	if f == nil {
+		return true
+	}
+	for _, cond := range f.conditions {
+		if cond.match(message) == cond.not {
+			atomic.AddUint64(&f.dropped, 1)
+			return false
+		}
+	}
+	return true
+}
+
+// Dropped number of messages which did not match the filter
+func (f *Filter) Dropped() uint64 {
+	if f == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&f.dropped)
+}
+
+func (c condition) match(message forwarder.Message) bool {
+	value, ok := message.Field(c.field)
+	if c.exists != nil && *c.exists != ok {
+		return false
+	}
+	if c.equals != nil && (!ok || value != *c.equals) {
+		return false
+	}
+	if c.in != nil && (!ok || !contains(c.in, value)) {
+		return false
+	}
+	if c.pattern != nil && (!ok || !c.pattern.MatchString(value)) {
+		return false
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
+	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
+)
+
+func TestNew(t *testing.T) {
+	scenarios := []struct {
+		name    string
+		entries []config.FilterEntry
+		valid   bool
+	}{
+		{
+			name:    "no conditions",
+			entries: nil,
+			valid:   true,
+		},
+		{
+			name:    "unknown field",
+			entries: []config.FilterEntry{{Field: "unknown", Pattern: ".*"}},
+			valid:   false,
+		},
+		{
+			name:    "broken pattern",
+			entries: []config.FilterEntry{{Field: "routingKey", Pattern: "("}},
+			valid:   false,
+		},
+	}
+	for _, scenario := range scenarios {
+		t.Log("Scenario name: ", scenario.name)
+		_, err := New(scenario.entries)
+		if scenario.valid && err != nil {
+			t.Errorf("Error should not occur. Error: %s", err.Error())
+		}
+		if !scenario.valid && err == nil {
+			t.Errorf("Error should occur")
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	created := "created"
+	exists := true
+	message := forwarder.Message{
+		Body:       `{"type":"created","user":{"country":"PL"}}`,
+		RoutingKey: "user.created",
+		Headers:    map[string]interface{}{"source": "crm"},
+	}
+	scenarios := []struct {
+		name    string
+		entries []config.FilterEntry
+		message forwarder.Message
+		match   bool
+	}{
+		{
+			name:    "no conditions",
+			message: message,
+			match:   true,
+		},
+		{
+			name:    "routing key pattern",
+			entries: []config.FilterEntry{{Field: "routingKey", Pattern: `^user\.`}},
+			message: message,
+			match:   true,
+		},
+		{
+			name:    "routing key pattern negated",
+			entries: []config.FilterEntry{{Field: "routingKey", Pattern: `^user\.`, Not: true}},
+			message: message,
+			match:   false,
+		},
+		{
+			name:    "header equals",
+			entries: []config.FilterEntry{{Field: "headers.source", Equals: &created}},
+			message: message,
+			match:   false,
+		},
+		{
+			name:    "missing header exists",
+			entries: []config.FilterEntry{{Field: "headers.missing", Exists: &exists}},
+			message: message,
+			match:   false,
+		},
+		{
+			name: "body fields",
+			entries: []config.FilterEntry{
+				{Field: "body.type", Equals: &created},
+				{Field: "body.user.country", In: []string{"PL", "DE"}}},
+			message: message,
+			match:   true,
+		},
+		{
+			name:    "body field of plain text",
+			entries: []config.FilterEntry{{Field: "body.type", Equals: &created}},
+			message: forwarder.Message{Body: "plain"},
+			match:   false,
+		},
+	}
+	for _, scenario := range scenarios {
+		t.Log("Scenario name: ", scenario.name)
+		filter, err := New(scenario.entries)
+		if err != nil {
+			t.Errorf("could not create filter: %s", err.Error())
+			continue
+		}
+		if filter.Match(scenario.message) != scenario.match {
+			t.Errorf("wrong match result, expected:%t", scenario.match)
+		}
+		dropped := uint64(1)
+		if scenario.match {
+			dropped = 0
+		}
+		if filter.Dropped() != dropped {
+			t.Errorf("wrong number of dropped messages, expected:%d, got:%d", dropped, filter.Dropped())
+		}
+	}
+}
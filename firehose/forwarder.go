@@ -0,0 +1,156 @@
+package firehose
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
+	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/firehose"
+	"github.com/aws/aws-sdk-go/service/firehose/firehoseiface"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Type forwarder type
+	Type = "Firehose"
+	// MaxBatchSize maximum number of records in PutRecordBatch request
+	MaxBatchSize = 500
+	// MaxBatchBytes maximum size of PutRecordBatch request
+	MaxBatchBytes = 4 * 1024 * 1024
+	// MaxRecordBytes maximum size of a single record
+	MaxRecordBytes = 1000 * 1024
+	// RecordTooLargeError error of a message exceeding record size limit
+	RecordTooLargeError = "Message exceeds Firehose record size limit"
+	// DefaultBatchInterval time after which incomplete batch is sent
+	DefaultBatchInterval = time.Second
+	recordDelimiter      = "\n"
+	serviceUnavailable   = "ServiceUnavailableException"
+)
+
+// Forwarder forwarding client
+type Forwarder struct {
+	name           string
+	firehoseClient firehoseiface.FirehoseAPI
+	deliveryStream string
+	batchSize      int
+	batchInterval  time.Duration
+	timeout        time.Duration
+}
+
+// CreateForwarder creates instance of forwarder
+func CreateForwarder(entry config.AmazonEntry, firehoseClient ...firehoseiface.FirehoseAPI) forwarder.Client {
+	var client firehoseiface.FirehoseAPI
+	if len(firehoseClient) > 0 {
+		client = firehoseClient[0]
+	} else {
+		client = firehose.New(session.Must(session.NewSession()))
+	}
+	batchSize, batchInterval, err := forwarder.BatchSettings(entry.Batch, MaxBatchSize, DefaultBatchInterval)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"forwarderName": entry.Name,
+			"error":         err.Error()}).Fatal("Could not parse batch interval")
+	}
+	timeout, err := forwarder.Timeout(entry.Timeout, forwarder.DefaultTimeout)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"forwarderName": entry.Name,
+			"error":         err.Error()}).Fatal("Invalid push timeout")
+	}
+	forwarder := Forwarder{entry.Name, client, entry.Target, batchSize, batchInterval, timeout}
+	log.WithField("forwarderName", forwarder.Name()).Info("Created forwarder")
+	return forwarder
+}
+
+// Name forwarder name
+func (f Forwarder) Name() string {
+	return f.name
+}
+
+// BatchSize maximum number of messages in a batch
+func (f Forwarder) BatchSize() int {
+	return f.batchSize
+}
+
+// BatchInterval time after which incomplete batch is forwarded
+func (f Forwarder) BatchInterval() time.Duration {
+	return f.batchInterval
+}
+
+// Push pushes message to forwarding infrastructure
+func (f Forwarder) Push(ctx context.Context, message forwarder.Message) error {
+	return f.PushBatch(ctx, []forwarder.Message{message})[0]
+}
+
+// PushBatch pushes newline delimited messages to forwarding infrastructure,
+// every request is cancelled when context is done or timeout passes
+func (f Forwarder) PushBatch(ctx context.Context, messages []forwarder.Message) []error {
+	errs := make([]error, len(messages))
+	var records []*firehose.Record
+	var indexes []int
+	size := 0
+	for i, message := range messages {
+		if message.Body == "" {
+			errs[i] = errors.New(forwarder.EmptyMessageError)
+			continue
+		}
+		data := message.Body
+		if !strings.HasSuffix(data, recordDelimiter) {
+			data += recordDelimiter
+		}
+		if len(data) > MaxRecordBytes {
+			errs[i] = errors.New(RecordTooLargeError)
+			continue
+		}
+		if len(records) == MaxBatchSize || (len(records) > 0 && size+len(data) > MaxBatchBytes) {
+			f.putRecordBatch(ctx, records, indexes, errs)
+			records, indexes, size = nil, nil, 0
+		}
+		records = append(records, &firehose.Record{Data: []byte(data)})
+		indexes = append(indexes, i)
+		size += len(data)
+	}
+	if len(records) > 0 {
+		f.putRecordBatch(ctx, records, indexes, errs)
+	}
+	return errs
+}
+
+// putRecordBatch sends records and stores errors under message indexes,
+// records rejected by unavailable service are marked as retryable
+func (f Forwarder) putRecordBatch(ctx context.Context, records []*firehose.Record, indexes []int, errs []error) {
+	params := &firehose.PutRecordBatchInput{
+		DeliveryStreamName: aws.String(f.deliveryStream),
+		Records:            records,
+	}
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+	resp, err := f.firehoseClient.PutRecordBatchWithContext(ctx, params)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"forwarderName": f.Name(),
+			"error":         err.Error()}).Error("Could not forward messages")
+		for _, i := range indexes {
+			errs[i] = err
+		}
+		return
+	}
+	for j, entry := range resp.RequestResponses {
+		if j < len(indexes) && entry.ErrorCode != nil {
+			err := errors.New(aws.StringValue(entry.ErrorCode) + ": " + aws.StringValue(entry.ErrorMessage))
+			if aws.StringValue(entry.ErrorCode) == serviceUnavailable {
+				err = forwarder.RetryableError{Err: err}
+			}
+			errs[indexes[j]] = err
+		}
+	}
+	log.WithFields(log.Fields{
+		"forwarderName": f.Name(),
+		"records":       len(records),
+		"failedRecords": aws.Int64Value(resp.FailedPutCount)}).Info("Forward succeeded")
+}
@@ -0,0 +1,146 @@
+package firehose
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
+	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/firehose"
+	"github.com/aws/aws-sdk-go/service/firehose/firehoseiface"
+)
+
+const (
+	badRequest   = "Bad request"
+	streamName   = "stream1"
+	failedRecord = "failed"
+)
+
+func TestCreateForwarder(t *testing.T) {
+	entry := config.AmazonEntry{Type: "Firehose",
+		Name:   "firehose-test",
+		Target: streamName,
+		Batch:  &config.BatchEntry{Size: 1000, Interval: "5s"},
+	}
+	forwarder := CreateForwarder(entry).(Forwarder)
+	if forwarder.Name() != entry.Name {
+		t.Errorf("wrong forwarder name, expected:%s, found: %s", entry.Name, forwarder.Name())
+	}
+	if forwarder.BatchSize() != MaxBatchSize {
+		t.Errorf("wrong batch size, expected:%d, found: %d", MaxBatchSize, forwarder.BatchSize())
+	}
+	if forwarder.BatchInterval() != 5*time.Second {
+		t.Errorf("wrong batch interval, expected:%s, found: %s", 5*time.Second, forwarder.BatchInterval())
+	}
+}
+
+func TestPush(t *testing.T) {
+	entry := config.AmazonEntry{Type: "Firehose",
+		Name:   "firehose-test",
+		Target: streamName,
+	}
+	scenarios := []struct {
+		name    string
+		message string
+		err     error
+	}{
+		{
+			name:    "empty message",
+			message: "",
+			err:     errors.New(forwarder.EmptyMessageError),
+		},
+		{
+			name:    "bad request",
+			message: badRequest,
+			err:     errors.New(badRequest),
+		},
+		{
+			name:    "success",
+			message: "abc",
+			err:     nil,
+		},
+	}
+	for _, scenario := range scenarios {
+		t.Log("Scenario name: ", scenario.name)
+		mock := &mockAmazonFirehose{}
+		client := CreateForwarder(entry, mock)
+		err := client.Push(context.Background(), forwarder.Message{Body: scenario.message})
+		if scenario.err == nil && err != nil {
+			t.Errorf("Error should not occur. Error: %s", err.Error())
+			continue
+		}
+		if scenario.err == nil {
+			continue
+		}
+		if err == nil || err.Error() != scenario.err.Error() {
+			t.Errorf("Wrong error, expecting:%v, got:%v", scenario.err, err)
+		}
+	}
+}
+
+func TestPushBatch(t *testing.T) {
+	entry := config.AmazonEntry{Type: "Firehose",
+		Name:   "firehose-test",
+		Target: streamName,
+	}
+	mock := &mockAmazonFirehose{}
+	client := CreateForwarder(entry, mock).(Forwarder)
+	messages := []forwarder.Message{{Body: "abc"}, {Body: ""}, {Body: failedRecord}, {Body: "def\n"}, {Body: strings.Repeat("a", MaxRecordBytes)}}
+	for i := 0; i < MaxBatchSize; i++ {
+		messages = append(messages, forwarder.Message{Body: "ghi"})
+	}
+	errs := client.PushBatch(context.Background(), messages)
+	if len(errs) != len(messages) {
+		t.Fatalf("wrong number of errors, expected:%d, got:%d", len(messages), len(errs))
+	}
+	for i, err := range errs {
+		shouldFail := i == 1 || i == 2 || i == 4
+		if shouldFail != (err != nil) {
+			t.Errorf("wrong result of message %d, error: %v", i, err)
+		}
+	}
+	if !forwarder.IsRetryable(errs[2]) {
+		t.Errorf("record rejected by unavailable service should be retryable, error: %v", errs[2])
+	}
+	if errs[4] == nil || errs[4].Error() != RecordTooLargeError || forwarder.IsRetryable(errs[4]) {
+		t.Errorf("too large record should fail, error: %v", errs[4])
+	}
+	if len(mock.batches) != 2 || len(mock.batches[0]) != MaxBatchSize || len(mock.batches[1]) != 3 {
+		t.Errorf("messages should be split into batches of at most %d records", MaxBatchSize)
+	}
+	for _, record := range mock.batches[0][:3] {
+		if !strings.HasSuffix(string(record.Data), "\n") || strings.Count(string(record.Data), "\n") != 1 {
+			t.Errorf("record should be newline delimited, got:%q", record.Data)
+		}
+	}
+}
+
+type mockAmazonFirehose struct {
+	firehoseiface.FirehoseAPI
+	batches [][]*firehose.Record
+}
+
+func (m *mockAmazonFirehose) PutRecordBatchWithContext(ctx aws.Context, input *firehose.PutRecordBatchInput, opts ...request.Option) (*firehose.PutRecordBatchOutput, error) {
+	if *input.DeliveryStreamName != streamName {
+		return nil, errors.New("Wrong delivery stream name")
+	}
+	if string(input.Records[0].Data) == badRequest+"\n" {
+		return nil, errors.New(badRequest)
+	}
+	m.batches = append(m.batches, input.Records)
+	resp := &firehose.PutRecordBatchOutput{FailedPutCount: aws.Int64(0)}
+	for _, record := range input.Records {
+		entry := &firehose.PutRecordBatchResponseEntry{RecordId: aws.String("id")}
+		if string(record.Data) == failedRecord+"\n" {
+			entry = &firehose.PutRecordBatchResponseEntry{ErrorCode: aws.String("ServiceUnavailableException"), ErrorMessage: aws.String("Slow down")}
+			resp.FailedPutCount = aws.Int64(*resp.FailedPutCount + 1)
+		}
+		resp.RequestResponses = append(resp.RequestResponses, entry)
+	}
+	return resp, nil
+}
@@ -0,0 +1,25 @@
+package forwarder
+
+import (
+	"time"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
+)
+
+// BatchSettings returns batch size limited by maxSize and batch interval, defaults are used for missing values
+func BatchSettings(entry *config.BatchEntry, maxSize int, defaultInterval time.Duration) (int, time.Duration, error) {
+	size, interval := maxSize, defaultInterval
+	if entry == nil {
+		return size, interval, nil
+	}
+	if entry.Size > 0 && entry.Size < maxSize {
+		size = entry.Size
+	}
+	if entry.Interval != "" {
+		var err error
+		if interval, err = time.ParseDuration(entry.Interval); err != nil {
+			return 0, 0, err
+		}
+	}
+	return size, interval, nil
+}
@@ -1,12 +1,77 @@
 package forwarder
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
 const (
 	// EmptyMessageError empty error message
 	EmptyMessageError = "message is empty"
+	// DefaultTimeout time limit of a single push
+	DefaultTimeout = 30 * time.Second
 )
 
-// Client interface to forwarding messages
+// Client interface to forwarding messages together with their metadata. Push is cancelled
+// when the context is done, forwarders also limit every push with their timeout
 type Client interface {
 	Name() string
-	Push(message string) error
+	Push(ctx context.Context, message Message) error
+}
+
+// Timeout parses push timeout of mapping entry, default timeout is used when it is empty
+func Timeout(value string, defaultTimeout time.Duration) (time.Duration, error) {
+	if value == "" {
+		return defaultTimeout, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("timeout has to be positive, found %s", value)
+	}
+	return timeout, nil
+}
+
+// BatchClient interface to forwarding messages in batches
+type BatchClient interface {
+	Client
+	BatchSize() int
+	BatchInterval() time.Duration
+	// PushBatch returns error for every message, nil if the message was forwarded
+	PushBatch(ctx context.Context, messages []Message) []error
+}
+
+// RetryableError transient forwarding error, e.g. throttling or unavailable service
+type RetryableError struct {
+	Err error
+}
+
+func (e RetryableError) Error() string {
+	return e.Err.Error()
+}
+
+// IsRetryable checks if forwarding error is transient: marked as retryable, timed out push
+// or retryable and throttling errors of AWS services
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if _, ok := err.(RetryableError); ok {
+		return true
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	// SDK treats unknown errors as retryable, only errors of AWS requests are checked
+	if awsErr, ok := err.(awserr.Error); ok {
+		return request.IsErrorRetryable(awsErr) || request.IsErrorThrottle(awsErr)
+	}
+	return false
 }
@@ -0,0 +1,120 @@
+package forwarder
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// RoutingKeyField routing key field name
+	RoutingKeyField = "routingKey"
+	// ExchangeField exchange field name
+	ExchangeField = "exchange"
+	// MessageIDField message id field name
+	MessageIDField = "messageId"
+	// TimestampField timestamp field name
+	TimestampField = "timestamp"
+	// HeadersPrefix prefix of header field names
+	HeadersPrefix = "headers."
+	// BodyPrefix prefix of JSON body field names
+	BodyPrefix = "body."
+)
+
+// Message message with its RabbitMQ metadata
+type Message struct {
+	Body          string
+	RoutingKey    string
+	Exchange      string
+	MessageID     string
+	CorrelationID string
+	ReplyTo       string
+	ContentType   string
+	Timestamp     time.Time
+	Headers       map[string]interface{}
+	// Reply sends response to ReplyTo queue, nil if the message does not expect reply
+	Reply Replier
+}
+
+// Replier publishes response with headers to the message sender
+type Replier func(body []byte, headers map[string]interface{}) error
+
+// Field returns message field value, e.g. routingKey, headers.name or body.user.id
+func (m Message) Field(name string) (string, bool) {
+	switch name {
+	case RoutingKeyField:
+		return m.RoutingKey, true
+	case ExchangeField:
+		return m.Exchange, true
+	case MessageIDField:
+		return m.MessageID, true
+	case TimestampField:
+		return m.Timestamp.UTC().Format(time.RFC3339), true
+	}
+	if strings.HasPrefix(name, HeadersPrefix) {
+		value, ok := m.Headers[strings.TrimPrefix(name, HeadersPrefix)]
+		if !ok {
+			return "", false
+		}
+		return fmt.Sprint(value), true
+	}
+	if strings.HasPrefix(name, BodyPrefix) {
+		return m.bodyField(strings.TrimPrefix(name, BodyPrefix))
+	}
+	return "", false
+}
+
+// BodyValue returns decoded value under dot separated path of JSON body. Numbers are decoded
+// as json.Number, so large integers like identifiers keep their exact text
+func (m Message) BodyValue(path string) (interface{}, bool) {
+	var value interface{}
+	decoder := json.NewDecoder(strings.NewReader(m.Body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil || decoder.More() {
+		return nil, false
+	}
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// bodyField returns text of value under dot separated path of JSON body
+func (m Message) bodyField(path string) (string, bool) {
+	value, ok := m.BodyValue(path)
+	if !ok {
+		return "", false
+	}
+	switch v := value.(type) {
+	case nil:
+		return "", true
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case map[string]interface{}, []interface{}:
+		bytes, err := json.Marshal(value)
+		if err != nil {
+			return "", false
+		}
+		return string(bytes), true
+	}
+	return fmt.Sprint(value), true
+}
+
+// ValidField checks if name is a supported message field
+func ValidField(name string) bool {
+	switch name {
+	case RoutingKeyField, ExchangeField, MessageIDField, TimestampField:
+		return true
+	}
+	return (strings.HasPrefix(name, HeadersPrefix) && len(name) > len(HeadersPrefix)) ||
+		(strings.HasPrefix(name, BodyPrefix) && len(name) > len(BodyPrefix))
+}
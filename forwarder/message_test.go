@@ -0,0 +1,57 @@
+package forwarder
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestField(t *testing.T) {
+	message := Message{
+		Body:       `{"order":{"id":20000000,"total":1234567.5,"lines":[{"sku":98765432101}],"paid":true,"note":null},"user":"john"}`,
+		RoutingKey: "order.created",
+		Exchange:   "orders",
+		MessageID:  "message1",
+		Timestamp:  time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Headers:    map[string]interface{}{"tenant": "tenant1", "version": 2},
+	}
+	scenarios := []struct {
+		name  string
+		field string
+		value string
+		found bool
+	}{
+		{name: "routing key", field: "routingKey", value: "order.created", found: true},
+		{name: "exchange", field: "exchange", value: "orders", found: true},
+		{name: "message id", field: "messageId", value: "message1", found: true},
+		{name: "timestamp", field: "timestamp", value: "2020-01-02T03:04:05Z", found: true},
+		{name: "header", field: "headers.version", value: "2", found: true},
+		{name: "missing header", field: "headers.region", found: false},
+		{name: "body string", field: "body.user", value: "john", found: true},
+		{name: "body large integer", field: "body.order.id", value: "20000000", found: true},
+		{name: "body decimal", field: "body.order.total", value: "1234567.5", found: true},
+		{name: "body boolean", field: "body.order.paid", value: "true", found: true},
+		{name: "body null", field: "body.order.note", value: "", found: true},
+		{name: "body array keeps numbers", field: "body.order.lines", value: `[{"sku":98765432101}]`, found: true},
+		{name: "missing body field", field: "body.order.currency", found: false},
+		{name: "path through string", field: "body.user.id", found: false},
+		{name: "unknown field", field: "priority", found: false},
+	}
+	for _, scenario := range scenarios {
+		t.Log("Scenario name: ", scenario.name)
+		value, found := message.Field(scenario.field)
+		if found != scenario.found {
+			t.Errorf("wrong lookup result of %s, expected %t, got %t", scenario.field, scenario.found, found)
+		}
+		if value != scenario.value {
+			t.Errorf("wrong value of %s, expected %s, got %s", scenario.field, scenario.value, value)
+		}
+	}
+
+	if value, found := message.BodyValue("order.id"); !found || value != json.Number("20000000") {
+		t.Errorf("body number should be decoded as JSON number, got %T %v", value, value)
+	}
+	if _, found := (Message{Body: "not json"}).Field("body.id"); found {
+		t.Errorf("field of non JSON body should not be found")
+	}
+}
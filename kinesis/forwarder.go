@@ -0,0 +1,220 @@
+package kinesis
+
+import (
+	"context"
+	"errors"
+	"time"
+	"unicode/utf8"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
+	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Type forwarder type
+	Type = "Kinesis"
+	// EmptyPartitionKeyError empty partition key error message
+	EmptyPartitionKeyError = "partition key is empty"
+	// PartitionKeyTooLongError error of partition key longer than MaxPartitionKeyLength
+	PartitionKeyTooLongError = "partition key is longer than 256 characters"
+	// RecordTooLargeError error of a message exceeding record size limit
+	RecordTooLargeError = "Message exceeds Kinesis record size limit"
+	// MaxPartitionKeyLength maximum number of partition key characters
+	MaxPartitionKeyLength = 256
+	// MaxBatchSize maximum number of records in PutRecords request
+	MaxBatchSize = 500
+	// MaxBatchBytes maximum size of PutRecords request, including partition keys
+	MaxBatchBytes = 5 * 1024 * 1024
+	// MaxRecordBytes maximum size of a single record, including partition key
+	MaxRecordBytes = 1024 * 1024
+	// DefaultBatchInterval time after which incomplete batch is sent
+	DefaultBatchInterval = time.Second
+	throughputExceeded   = "ProvisionedThroughputExceededException"
+	internalFailure      = "InternalFailure"
+)
+
+// Forwarder forwarding client
+type Forwarder struct {
+	name          string
+	kinesisClient kinesisiface.KinesisAPI
+	stream        string
+	partitionKey  string
+	timeout       time.Duration
+}
+
+// BatchForwarder forwarding client putting batches of messages with PutRecords
+type BatchForwarder struct {
+	Forwarder
+	batchSize     int
+	batchInterval time.Duration
+}
+
+// CreateForwarder creates instance of forwarder, batch forwarder if batching is configured
+func CreateForwarder(entry config.AmazonEntry, kinesisClient ...kinesisiface.KinesisAPI) forwarder.Client {
+	var client kinesisiface.KinesisAPI
+	if len(kinesisClient) > 0 {
+		client = kinesisClient[0]
+	} else {
+		client = kinesis.New(session.Must(session.NewSession()))
+	}
+	partitionKey := entry.PartitionKey
+	if partitionKey == "" {
+		partitionKey = forwarder.RoutingKeyField
+	}
+	if !forwarder.ValidField(partitionKey) {
+		log.WithFields(log.Fields{
+			"forwarderName": entry.Name,
+			"partitionKey":  partitionKey}).Fatal("Unknown partition key field")
+	}
+	batchSize, batchInterval, err := forwarder.BatchSettings(entry.Batch, MaxBatchSize, DefaultBatchInterval)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"forwarderName": entry.Name,
+			"error":         err.Error()}).Fatal("Could not parse batch interval")
+	}
+	timeout, err := forwarder.Timeout(entry.Timeout, forwarder.DefaultTimeout)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"forwarderName": entry.Name,
+			"error":         err.Error()}).Fatal("Invalid push timeout")
+	}
+	forwarder := Forwarder{entry.Name, client, entry.Target, partitionKey, timeout}
+	log.WithField("forwarderName", forwarder.Name()).Info("Created forwarder")
+	if entry.Batch == nil {
+		return forwarder
+	}
+	return BatchForwarder{forwarder, batchSize, batchInterval}
+}
+
+// Name forwarder name
+func (f Forwarder) Name() string {
+	return f.name
+}
+
+// Push pushes message to forwarding infrastructure with partition key taken from message,
+// push is cancelled when context is done or timeout passes
+func (f Forwarder) Push(ctx context.Context, message forwarder.Message) error {
+	if message.Body == "" {
+		return errors.New(forwarder.EmptyMessageError)
+	}
+	partitionKey, err := f.messagePartitionKey(message)
+	if err != nil {
+		return err
+	}
+	params := &kinesis.PutRecordInput{
+		Data:         []byte(message.Body),
+		PartitionKey: aws.String(partitionKey),
+		StreamName:   aws.String(f.stream),
+	}
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+	resp, err := f.kinesisClient.PutRecordWithContext(ctx, params)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"forwarderName": f.Name(),
+			"error":         err.Error()}).Error("Could not forward message")
+		return err
+	}
+	log.WithFields(log.Fields{
+		"forwarderName":  f.Name(),
+		"shardID":        resp.ShardId,
+		"sequenceNumber": resp.SequenceNumber}).Info("Forward succeeded")
+	return nil
+}
+
+// messagePartitionKey partition key taken from message, Kinesis accepts keys of 1 to 256 characters
+func (f Forwarder) messagePartitionKey(message forwarder.Message) (string, error) {
+	partitionKey, _ := message.Field(f.partitionKey)
+	if partitionKey == "" {
+		return "", errors.New(EmptyPartitionKeyError)
+	}
+	if utf8.RuneCountInString(partitionKey) > MaxPartitionKeyLength {
+		return "", errors.New(PartitionKeyTooLongError)
+	}
+	return partitionKey, nil
+}
+
+// BatchSize maximum number of messages in a batch
+func (f BatchForwarder) BatchSize() int {
+	return f.batchSize
+}
+
+// BatchInterval time after which incomplete batch is forwarded
+func (f BatchForwarder) BatchInterval() time.Duration {
+	return f.batchInterval
+}
+
+// PushBatch puts messages as records of PutRecords requests within request limits,
+// every request is cancelled when context is done or timeout passes
+func (f BatchForwarder) PushBatch(ctx context.Context, messages []forwarder.Message) []error {
+	errs := make([]error, len(messages))
+	var records []*kinesis.PutRecordsRequestEntry
+	var indexes []int
+	size := 0
+	for i, message := range messages {
+		if message.Body == "" {
+			errs[i] = errors.New(forwarder.EmptyMessageError)
+			continue
+		}
+		partitionKey, err := f.messagePartitionKey(message)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		recordSize := len(message.Body) + len(partitionKey)
+		if recordSize > MaxRecordBytes {
+			errs[i] = errors.New(RecordTooLargeError)
+			continue
+		}
+		if len(records) == MaxBatchSize || (len(records) > 0 && size+recordSize > MaxBatchBytes) {
+			f.putRecords(ctx, records, indexes, errs)
+			records, indexes, size = nil, nil, 0
+		}
+		records = append(records, &kinesis.PutRecordsRequestEntry{Data: []byte(message.Body), PartitionKey: aws.String(partitionKey)})
+		indexes = append(indexes, i)
+		size += recordSize
+	}
+	if len(records) > 0 {
+		f.putRecords(ctx, records, indexes, errs)
+	}
+	return errs
+}
+
+// putRecords sends records and stores errors under message indexes,
+// records failed because of throughput or internal failure are marked as retryable
+func (f BatchForwarder) putRecords(ctx context.Context, records []*kinesis.PutRecordsRequestEntry, indexes []int, errs []error) {
+	params := &kinesis.PutRecordsInput{
+		Records:    records,
+		StreamName: aws.String(f.stream),
+	}
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+	resp, err := f.kinesisClient.PutRecordsWithContext(ctx, params)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"forwarderName": f.Name(),
+			"error":         err.Error()}).Error("Could not forward messages")
+		for _, i := range indexes {
+			errs[i] = err
+		}
+		return
+	}
+	for j, entry := range resp.Records {
+		if j < len(indexes) && entry.ErrorCode != nil {
+			err := errors.New(aws.StringValue(entry.ErrorCode) + ": " + aws.StringValue(entry.ErrorMessage))
+			if code := aws.StringValue(entry.ErrorCode); code == throughputExceeded || code == internalFailure {
+				err = forwarder.RetryableError{Err: err}
+			}
+			errs[indexes[j]] = err
+		}
+	}
+	log.WithFields(log.Fields{
+		"forwarderName": f.Name(),
+		"records":       len(records),
+		"failedRecords": aws.Int64Value(resp.FailedRecordCount)}).Info("Forward succeeded")
+}
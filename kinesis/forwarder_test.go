@@ -0,0 +1,205 @@
+package kinesis
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
+	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+)
+
+const (
+	badRequest   = "Bad request"
+	failedRecord = "failed"
+)
+
+func TestCreateForwarder(t *testing.T) {
+	entry := config.AmazonEntry{Type: "Kinesis",
+		Name:   "kinesis-test",
+		Target: "stream1",
+	}
+	forwarder := CreateForwarder(entry)
+	if forwarder.Name() != entry.Name {
+		t.Errorf("wrong forwarder name, expected:%s, found: %s", entry.Name, forwarder.Name())
+	}
+	entry.Batch = &config.BatchEntry{Size: 1000, Interval: "5s"}
+	batchForwarder, ok := CreateForwarder(entry).(BatchForwarder)
+	if !ok {
+		t.Fatalf("forwarder with batch section should forward batches")
+	}
+	if batchForwarder.BatchSize() != MaxBatchSize || batchForwarder.BatchInterval() != 5*time.Second {
+		t.Errorf("wrong batch settings, found size: %d, interval: %s", batchForwarder.BatchSize(), batchForwarder.BatchInterval())
+	}
+}
+
+func TestPushMessage(t *testing.T) {
+	streamName := "stream1"
+	scenarios := []struct {
+		name         string
+		partitionKey string
+		mock         kinesisiface.KinesisAPI
+		message      forwarder.Message
+		err          error
+	}{
+		{
+			name:    "empty message",
+			mock:    mockAmazonKinesis{stream: streamName, partitionKey: "user.created", message: ""},
+			message: forwarder.Message{Body: "", RoutingKey: "user.created"},
+			err:     errors.New(forwarder.EmptyMessageError),
+		},
+		{
+			name:    "empty partition key",
+			mock:    mockAmazonKinesis{stream: streamName, partitionKey: "", message: "abc"},
+			message: forwarder.Message{Body: "abc"},
+			err:     errors.New(EmptyPartitionKeyError),
+		},
+		{
+			name:    "too long partition key",
+			mock:    mockAmazonKinesis{stream: streamName, message: "abc"},
+			message: forwarder.Message{Body: "abc", RoutingKey: strings.Repeat("a", MaxPartitionKeyLength+1)},
+			err:     errors.New(PartitionKeyTooLongError),
+		},
+		{
+			name:    "bad request",
+			mock:    mockAmazonKinesis{stream: streamName, partitionKey: "user.created", message: badRequest},
+			message: forwarder.Message{Body: badRequest, RoutingKey: "user.created"},
+			err:     errors.New(badRequest),
+		},
+		{
+			name:    "routing key partition key",
+			mock:    mockAmazonKinesis{stream: streamName, partitionKey: "user.created", message: "abc"},
+			message: forwarder.Message{Body: "abc", RoutingKey: "user.created"},
+		},
+		{
+			name:         "header partition key",
+			partitionKey: "headers.tenant",
+			mock:         mockAmazonKinesis{stream: streamName, partitionKey: "tenant1", message: "abc"},
+			message:      forwarder.Message{Body: "abc", Headers: map[string]interface{}{"tenant": "tenant1"}},
+		},
+		{
+			name:         "body field partition key",
+			partitionKey: "body.user.id",
+			mock:         mockAmazonKinesis{stream: streamName, partitionKey: "42", message: `{"user":{"id":42}}`},
+			message:      forwarder.Message{Body: `{"user":{"id":42}}`},
+		},
+	}
+	for _, scenario := range scenarios {
+		t.Log("Scenario name: ", scenario.name)
+		entry := config.AmazonEntry{Type: "Kinesis",
+			Name:         "kinesis-test",
+			Target:       streamName,
+			PartitionKey: scenario.partitionKey,
+		}
+		forwarder := CreateForwarder(entry, scenario.mock).(Forwarder)
+		err := forwarder.Push(context.Background(), scenario.message)
+		if scenario.err == nil && err != nil {
+			t.Errorf("Error should not occur. Error: %s", err.Error())
+			continue
+		}
+		if scenario.err == nil {
+			continue
+		}
+		if err == nil {
+			t.Errorf("Error should occur. Expected: %s", scenario.err.Error())
+			continue
+		}
+		if err.Error() != scenario.err.Error() {
+			t.Errorf("Wrong error, expecting:%v, got:%v", scenario.err, err)
+		}
+	}
+}
+
+func TestPushBatch(t *testing.T) {
+	entry := config.AmazonEntry{Type: "Kinesis",
+		Name:   "kinesis-test",
+		Target: "stream1",
+		Batch:  &config.BatchEntry{},
+	}
+	mock := &mockBatchKinesis{}
+	client := CreateForwarder(entry, mock).(BatchForwarder)
+	messages := []forwarder.Message{
+		{Body: "abc", RoutingKey: "user.created"},
+		{Body: "", RoutingKey: "user.created"},
+		{Body: "abc"},
+		{Body: failedRecord, RoutingKey: "user.created"},
+		{Body: "abc", RoutingKey: strings.Repeat("a", MaxPartitionKeyLength+1)},
+		{Body: strings.Repeat("a", MaxRecordBytes), RoutingKey: "user.created"},
+	}
+	for i := 0; i < MaxBatchSize; i++ {
+		messages = append(messages, forwarder.Message{Body: "def", RoutingKey: "user.updated"})
+	}
+	errs := client.PushBatch(context.Background(), messages)
+	if len(errs) != len(messages) {
+		t.Fatalf("wrong number of errors, expected:%d, got:%d", len(messages), len(errs))
+	}
+	for i, err := range errs {
+		shouldFail := i >= 1 && i <= 5
+		if shouldFail != (err != nil) {
+			t.Errorf("wrong result of message %d, error: %v", i, err)
+		}
+	}
+	if !forwarder.IsRetryable(errs[3]) {
+		t.Errorf("record failed because of throughput should be retryable, error: %v", errs[3])
+	}
+	if errs[4] == nil || errs[4].Error() != PartitionKeyTooLongError || errs[5] == nil || errs[5].Error() != RecordTooLargeError {
+		t.Errorf("invalid records should fail before request, errors: %v, %v", errs[4], errs[5])
+	}
+	if len(mock.batches) != 2 || len(mock.batches[0]) != MaxBatchSize || len(mock.batches[1]) != 2 {
+		t.Errorf("messages should be split into batches of at most %d records", MaxBatchSize)
+	}
+	if key := aws.StringValue(mock.batches[0][0].PartitionKey); key != "user.created" {
+		t.Errorf("wrong partition key, expected: user.created, got: %s", key)
+	}
+}
+
+type mockAmazonKinesis struct {
+	kinesisiface.KinesisAPI
+	stream       string
+	partitionKey string
+	message      string
+}
+
+func (m mockAmazonKinesis) PutRecordWithContext(ctx aws.Context, input *kinesis.PutRecordInput, opts ...request.Option) (*kinesis.PutRecordOutput, error) {
+	if *input.StreamName != m.stream {
+		return nil, errors.New("Wrong stream name")
+	}
+	if *input.PartitionKey != m.partitionKey {
+		return nil, errors.New("Wrong partition key")
+	}
+	if string(input.Data) != m.message {
+		return nil, errors.New("Wrong message body")
+	}
+	if string(input.Data) == badRequest {
+		return nil, errors.New(badRequest)
+	}
+	return &kinesis.PutRecordOutput{ShardId: aws.String("shardId-000000000000"), SequenceNumber: aws.String("1")}, nil
+}
+
+type mockBatchKinesis struct {
+	kinesisiface.KinesisAPI
+	batches [][]*kinesis.PutRecordsRequestEntry
+}
+
+func (m *mockBatchKinesis) PutRecordsWithContext(ctx aws.Context, input *kinesis.PutRecordsInput, opts ...request.Option) (*kinesis.PutRecordsOutput, error) {
+	if *input.StreamName != "stream1" {
+		return nil, errors.New("Wrong stream name")
+	}
+	m.batches = append(m.batches, input.Records)
+	resp := &kinesis.PutRecordsOutput{FailedRecordCount: aws.Int64(0)}
+	for _, record := range input.Records {
+		entry := &kinesis.PutRecordsResultEntry{ShardId: aws.String("shardId-000000000000"), SequenceNumber: aws.String("1")}
+		if string(record.Data) == failedRecord {
+			entry = &kinesis.PutRecordsResultEntry{ErrorCode: aws.String("ProvisionedThroughputExceededException"), ErrorMessage: aws.String("Rate exceeded")}
+			resp.FailedRecordCount = aws.Int64(*resp.FailedRecordCount + 1)
+		}
+		resp.Records = append(resp.Records, entry)
+	}
+	return resp, nil
+}
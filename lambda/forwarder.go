@@ -1,7 +1,11 @@
 package lambda
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"time"
+
 	"github.com/AirHelp/rabbit-amazon-forwarder/config"
 	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
 	"github.com/aws/aws-sdk-go/aws"
@@ -14,13 +18,24 @@ import (
 const (
 	// Type forwarder type
 	Type = "Lambda"
+	// MaxSyncPayloadSize payload limit of synchronous invocation
+	MaxSyncPayloadSize = 6 * 1024 * 1024
+	// MaxAsyncPayloadSize payload limit of asynchronous invocation
+	MaxAsyncPayloadSize = 1024 * 1024
+	// FunctionErrorHeader reply header with function error type
+	FunctionErrorHeader = "x-function-error"
+	// DefaultSyncTimeout default push timeout of synchronous invocation, maximum function execution time
+	DefaultSyncTimeout = 15 * time.Minute
 )
 
 // Forwarder forwarding client
 type Forwarder struct {
-	name         string
-	lambdaClient lambdaiface.LambdaAPI
-	function     string
+	name           string
+	lambdaClient   lambdaiface.LambdaAPI
+	function       string
+	invocationType string
+	qualifier      string
+	timeout        time.Duration
 }
 
 // CreateForwarder creates instance of forwarder
@@ -31,7 +46,26 @@ func CreateForwarder(entry config.AmazonEntry, lambdaClient ...lambdaiface.Lambd
 	} else {
 		client = lambda.New(session.Must(session.NewSession()))
 	}
-	forwarder := Forwarder{entry.Name, client, entry.Target}
+	invocationType := entry.InvocationType
+	if invocationType == "" {
+		invocationType = lambda.InvocationTypeRequestResponse
+	}
+	if maxPayloadSize(invocationType) == 0 {
+		log.WithFields(log.Fields{
+			"forwarderName":  entry.Name,
+			"invocationType": invocationType}).Fatal("Unknown invocation type")
+	}
+	defaultTimeout := forwarder.DefaultTimeout
+	if invocationType == lambda.InvocationTypeRequestResponse {
+		defaultTimeout = DefaultSyncTimeout
+	}
+	timeout, err := forwarder.Timeout(entry.Timeout, defaultTimeout)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"forwarderName": entry.Name,
+			"error":         err.Error()}).Fatal("Invalid push timeout")
+	}
+	forwarder := Forwarder{entry.Name, client, entry.Target, invocationType, entry.Qualifier, timeout}
 	log.WithField("forwarderName", forwarder.Name()).Info("Created forwarder")
 	return forwarder
 }
@@ -41,22 +75,45 @@ func (f Forwarder) Name() string {
 	return f.name
 }
 
-// Push pushes message to forwarding infrastructure
-func (f Forwarder) Push(message string) error {
+// Push invokes function with message, invocation is cancelled when context is done or timeout passes.
+// In RequestResponse mode function response is sent to message ReplyTo queue
+func (f Forwarder) Push(ctx context.Context, msg forwarder.Message) error {
+	message := msg.Body
 	if message == "" {
 		return errors.New(forwarder.EmptyMessageError)
 	}
+	if limit := maxPayloadSize(f.invocationType); len(message) > limit {
+		err := fmt.Errorf("message size %d bytes exceeds %s invocation payload limit of %d bytes", len(message), f.invocationType, limit)
+		log.WithFields(log.Fields{
+			"forwarderName": f.Name(),
+			"error":         err.Error()}).Error("Could not forward message")
+		return err
+	}
 	params := &lambda.InvokeInput{
-		FunctionName: aws.String(f.function),
-		Payload:      []byte(message),
+		FunctionName:   aws.String(f.function),
+		InvocationType: aws.String(f.invocationType),
+		Payload:        []byte(message),
 	}
-	resp, err := f.lambdaClient.Invoke(params)
+	if f.qualifier != "" {
+		params.Qualifier = aws.String(f.qualifier)
+	}
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+	resp, err := f.lambdaClient.InvokeWithContext(ctx, params)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"forwarderName": f.Name(),
 			"error":         err.Error()}).Error("Could not forward message")
 		return err
 	}
+	if err = f.reply(msg, resp); err != nil {
+		log.WithFields(log.Fields{
+			"forwarderName": f.Name(),
+			"replyTo":       msg.ReplyTo,
+			"correlationID": msg.CorrelationID,
+			"error":         err.Error()}).Error("Could not send function response")
+		return err
+	}
 	if resp.FunctionError != nil {
 		log.WithFields(log.Fields{
 			"forwarderName": f.Name(),
@@ -68,3 +125,26 @@ func (f Forwarder) Push(message string) error {
 		"statusCode":    resp.StatusCode}).Info("Forward succeeded")
 	return nil
 }
+
+// reply sends function response to RPC client, function errors are marked with header
+func (f Forwarder) reply(msg forwarder.Message, resp *lambda.InvokeOutput) error {
+	if msg.Reply == nil || msg.CorrelationID == "" || f.invocationType != lambda.InvocationTypeRequestResponse {
+		return nil
+	}
+	var headers map[string]interface{}
+	if resp.FunctionError != nil {
+		headers = map[string]interface{}{FunctionErrorHeader: *resp.FunctionError}
+	}
+	return msg.Reply(resp.Payload, headers)
+}
+
+// maxPayloadSize payload limit of invocation type, 0 for unknown type
+func maxPayloadSize(invocationType string) int {
+	switch invocationType {
+	case lambda.InvocationTypeRequestResponse, lambda.InvocationTypeDryRun:
+		return MaxSyncPayloadSize
+	case lambda.InvocationTypeEvent:
+		return MaxAsyncPayloadSize
+	}
+	return 0
+}
@@ -1,12 +1,15 @@
 package lambda
 
 import (
+	"context"
 	"errors"
+	"strings"
 	"testing"
 
 	"github.com/AirHelp/rabbit-amazon-forwarder/config"
 	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/lambda"
 	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
 )
@@ -79,8 +82,8 @@ func TestPush(t *testing.T) {
 	}
 	for _, scenario := range scenarios {
 		t.Log("Scenario name: ", scenario.name)
-		forwarder := CreateForwarder(entry, scenario.mock)
-		err := forwarder.Push(scenario.message)
+		client := CreateForwarder(entry, scenario.mock)
+		err := client.Push(context.Background(), forwarder.Message{Body: scenario.message})
 		if scenario.err == nil && err != nil {
 			t.Errorf("Error should not occur. Error: %s", err.Error())
 			return
@@ -98,6 +101,150 @@ func TestPush(t *testing.T) {
 	}
 }
 
+func TestPushInvocation(t *testing.T) {
+	functionName := "function1-test"
+	scenarios := []struct {
+		name           string
+		entry          config.AmazonEntry
+		message        string
+		invocationType string
+		qualifier      string
+		err            error
+	}{
+		{
+			name:           "default invocation",
+			message:        "abc",
+			invocationType: lambda.InvocationTypeRequestResponse,
+		},
+		{
+			name:           "asynchronous invocation of alias",
+			entry:          config.AmazonEntry{InvocationType: lambda.InvocationTypeEvent, Qualifier: "live"},
+			message:        "abc",
+			invocationType: lambda.InvocationTypeEvent,
+			qualifier:      "live",
+		},
+		{
+			name:    "asynchronous payload too large",
+			entry:   config.AmazonEntry{InvocationType: lambda.InvocationTypeEvent},
+			message: strings.Repeat("a", MaxAsyncPayloadSize+1),
+			err:     errors.New("message size 1048577 bytes exceeds Event invocation payload limit of 1048576 bytes"),
+		},
+		{
+			name:           "asynchronous payload above former limit",
+			entry:          config.AmazonEntry{InvocationType: lambda.InvocationTypeEvent},
+			message:        strings.Repeat("a", 512*1024),
+			invocationType: lambda.InvocationTypeEvent,
+		},
+		{
+			name:    "synchronous payload too large",
+			message: strings.Repeat("a", MaxSyncPayloadSize+1),
+			err:     errors.New("message size 6291457 bytes exceeds RequestResponse invocation payload limit of 6291456 bytes"),
+		},
+	}
+	for _, scenario := range scenarios {
+		t.Log("Scenario name: ", scenario.name)
+		scenario.entry.Type, scenario.entry.Name, scenario.entry.Target = "Lambda", "lambda-test", functionName
+		mock := &mockInvocationLambda{}
+		client := CreateForwarder(scenario.entry, mock)
+		err := client.Push(context.Background(), forwarder.Message{Body: scenario.message})
+		if scenario.err != nil {
+			if err == nil || err.Error() != scenario.err.Error() {
+				t.Errorf("Wrong error, expecting:%v, got:%v", scenario.err, err)
+			}
+			if mock.input != nil {
+				t.Errorf("function should not be invoked")
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Error should not occur. Error: %s", err.Error())
+			continue
+		}
+		if *mock.input.InvocationType != scenario.invocationType {
+			t.Errorf("wrong invocation type, expected:%s, got:%s", scenario.invocationType, *mock.input.InvocationType)
+		}
+		if aws.StringValue(mock.input.Qualifier) != scenario.qualifier {
+			t.Errorf("wrong qualifier, expected:%s, got:%s", scenario.qualifier, aws.StringValue(mock.input.Qualifier))
+		}
+	}
+}
+
+func TestPushMessageReply(t *testing.T) {
+	functionName := "function1-test"
+	scenarios := []struct {
+		name     string
+		entry    config.AmazonEntry
+		message  forwarder.Message
+		resp     lambda.InvokeOutput
+		replyErr error
+		replied  bool
+		headers  map[string]interface{}
+		err      error
+	}{
+		{
+			name:    "reply",
+			message: forwarder.Message{Body: "abc", ReplyTo: "rpc-queue", CorrelationID: "1"},
+			resp:    lambda.InvokeOutput{StatusCode: aws.Int64(200), Payload: []byte(`{"result":1}`)},
+			replied: true,
+		},
+		{
+			name:    "no correlation id",
+			message: forwarder.Message{Body: "abc", ReplyTo: "rpc-queue"},
+			resp:    lambda.InvokeOutput{StatusCode: aws.Int64(200), Payload: []byte(`{"result":1}`)},
+		},
+		{
+			name:    "asynchronous invocation",
+			entry:   config.AmazonEntry{InvocationType: lambda.InvocationTypeEvent},
+			message: forwarder.Message{Body: "abc", ReplyTo: "rpc-queue", CorrelationID: "1"},
+			resp:    lambda.InvokeOutput{StatusCode: aws.Int64(202)},
+		},
+		{
+			name:    "function error",
+			message: forwarder.Message{Body: "abc", ReplyTo: "rpc-queue", CorrelationID: "1"},
+			resp:    lambda.InvokeOutput{StatusCode: aws.Int64(200), FunctionError: aws.String(unhandledError), Payload: []byte(`{"errorMessage":"failed"}`)},
+			replied: true,
+			headers: map[string]interface{}{FunctionErrorHeader: unhandledError},
+			err:     errors.New(unhandledError),
+		},
+		{
+			name:     "reply error",
+			message:  forwarder.Message{Body: "abc", ReplyTo: "rpc-queue", CorrelationID: "1"},
+			resp:     lambda.InvokeOutput{StatusCode: aws.Int64(200), Payload: []byte(`{"result":1}`)},
+			replyErr: errors.New("channel closed"),
+			replied:  true,
+			err:      errors.New("channel closed"),
+		},
+	}
+	for _, scenario := range scenarios {
+		t.Log("Scenario name: ", scenario.name)
+		scenario.entry.Type, scenario.entry.Name, scenario.entry.Target = "Lambda", "lambda-test", functionName
+		var replied []byte
+		var replyHeaders map[string]interface{}
+		scenario.message.Reply = func(body []byte, headers map[string]interface{}) error {
+			replied, replyHeaders = body, headers
+			return scenario.replyErr
+		}
+		mock := mockAmazonLambda{resp: scenario.resp, function: functionName, message: scenario.message.Body}
+		client := CreateForwarder(scenario.entry, mock).(Forwarder)
+		err := client.Push(context.Background(), scenario.message)
+		if scenario.err == nil && err != nil {
+			t.Errorf("Error should not occur. Error: %s", err.Error())
+		}
+		if scenario.err != nil && (err == nil || err.Error() != scenario.err.Error()) {
+			t.Errorf("Wrong error, expecting:%v, got:%v", scenario.err, err)
+		}
+		if scenario.replied != (replied != nil) {
+			t.Errorf("wrong reply, expected:%t, got:%s", scenario.replied, replied)
+		}
+		if scenario.replied && string(replied) != string(scenario.resp.Payload) {
+			t.Errorf("wrong reply body, expected:%s, got:%s", scenario.resp.Payload, replied)
+		}
+		if scenario.headers != nil && replyHeaders[FunctionErrorHeader] != scenario.headers[FunctionErrorHeader] {
+			t.Errorf("wrong reply headers, expected:%v, got:%v", scenario.headers, replyHeaders)
+		}
+	}
+}
+
 type mockAmazonLambda struct {
 	lambdaiface.LambdaAPI
 	resp     lambda.InvokeOutput
@@ -105,7 +252,7 @@ type mockAmazonLambda struct {
 	message  string
 }
 
-func (m mockAmazonLambda) Invoke(input *lambda.InvokeInput) (*lambda.InvokeOutput, error) {
+func (m mockAmazonLambda) InvokeWithContext(ctx aws.Context, input *lambda.InvokeInput, opts ...request.Option) (*lambda.InvokeOutput, error) {
 	if *input.FunctionName != m.function {
 		return nil, errors.New("Wrong function name")
 	}
@@ -117,3 +264,13 @@ func (m mockAmazonLambda) Invoke(input *lambda.InvokeInput) (*lambda.InvokeOutpu
 	}
 	return &m.resp, nil
 }
+
+type mockInvocationLambda struct {
+	lambdaiface.LambdaAPI
+	input *lambda.InvokeInput
+}
+
+func (m *mockInvocationLambda) InvokeWithContext(ctx aws.Context, input *lambda.InvokeInput, opts ...request.Option) (*lambda.InvokeOutput, error) {
+	m.input = input
+	return &lambda.InvokeOutput{StatusCode: aws.Int64(202)}, nil
+}
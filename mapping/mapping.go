@@ -10,11 +10,17 @@ import (
 
 	"github.com/AirHelp/rabbit-amazon-forwarder/config"
 	"github.com/AirHelp/rabbit-amazon-forwarder/consumer"
+	"github.com/AirHelp/rabbit-amazon-forwarder/dynamodb"
+	"github.com/AirHelp/rabbit-amazon-forwarder/eventbridge"
+	"github.com/AirHelp/rabbit-amazon-forwarder/firehose"
 	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
+	"github.com/AirHelp/rabbit-amazon-forwarder/kinesis"
 	"github.com/AirHelp/rabbit-amazon-forwarder/lambda"
 	"github.com/AirHelp/rabbit-amazon-forwarder/rabbitmq"
+	"github.com/AirHelp/rabbit-amazon-forwarder/s3"
 	"github.com/AirHelp/rabbit-amazon-forwarder/sns"
 	"github.com/AirHelp/rabbit-amazon-forwarder/sqs"
+	"github.com/AirHelp/rabbit-amazon-forwarder/webhook"
 )
 
 type pairs []pair
@@ -85,8 +91,23 @@ func (h helperImpl) createConsumer(entry config.RabbitEntry) consumer.Client {
 		"consumerName": entry.Name}).Info("Creating consumer")
 	switch entry.Type {
 	case rabbitmq.Type:
-		rabbitConnector := connector.CreateConnector(entry.ConnectionURL)
+		rabbitConnector, err := connector.CreateConnectorWithConfig(entry.Name, entry.AMQP, entry.TLS, entry.ConnectionURLs...)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"consumerName": entry.Name,
+				"error":        err.Error()}).Fatal("Invalid connection settings")
+		}
 		return rabbitmq.CreateConsumer(entry, rabbitConnector)
+	case rabbitmq.StreamType:
+		rabbitConnector, err := connector.CreateConnectorWithConfig(entry.Name, entry.AMQP, entry.TLS, entry.ConnectionURLs...)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"consumerName": entry.Name,
+				"error":        err.Error()}).Fatal("Invalid connection settings")
+		}
+		return rabbitmq.CreateStreamConsumer(entry, rabbitConnector)
+	case sqs.Type:
+		return sqs.CreateConsumer(entry)
 	}
 	return nil
 }
@@ -102,6 +123,26 @@ func (h helperImpl) createForwarder(entry config.AmazonEntry) forwarder.Client {
 		return sqs.CreateForwarder(entry)
 	case lambda.Type:
 		return lambda.CreateForwarder(entry)
+	case kinesis.Type:
+		return kinesis.CreateForwarder(entry)
+	case firehose.Type:
+		return firehose.CreateForwarder(entry)
+	case eventbridge.Type:
+		return eventbridge.CreateForwarder(entry)
+	case s3.Type:
+		return s3.CreateForwarder(entry)
+	case dynamodb.Type:
+		return dynamodb.CreateForwarder(entry)
+	case webhook.Type:
+		return webhook.CreateForwarder(entry)
+	case rabbitmq.Type:
+		rabbitConnector, err := connector.CreateConnectorWithConfig(entry.Name, entry.AMQP, entry.TLS, entry.ConnectionURLs...)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"forwarderName": entry.Name,
+				"error":         err.Error()}).Fatal("Invalid connection settings")
+		}
+		return rabbitmq.CreateForwarder(entry, rabbitConnector)
 	}
 	return nil
 }
@@ -0,0 +1,101 @@
+package offload
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
+	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultThreshold SNS/SQS message size limit
+	DefaultThreshold = 256 * 1024
+	// PayloadSizeAttribute message attribute with the original payload size
+	PayloadSizeAttribute = "ExtendedPayloadSize"
+	// PointerClass class name of the pointer used by AWS extended client libraries
+	PointerClass = "software.amazon.payloadoffloading.PayloadS3Pointer"
+)
+
+// Offloader stores large payloads in S3 and replaces them with a pointer
+type Offloader struct {
+	s3Client  s3iface.S3API
+	bucket    string
+	prefix    string
+	threshold int
+	always    bool
+}
+
+// Pointer reference to the offloaded payload
+type Pointer struct {
+	Bucket string `json:"s3BucketName"`
+	Key    string `json:"s3Key"`
+}
+
+// New creates offloader from mapping entry
+func New(entry config.OffloadEntry, s3Client ...s3iface.S3API) *Offloader {
+	var client s3iface.S3API
+	if len(s3Client) > 0 {
+		client = s3Client[0]
+	} else {
+		client = s3.New(session.Must(session.NewSession()), awsConfig(entry))
+	}
+	threshold := entry.Threshold
+	if threshold <= 0 {
+		threshold = DefaultThreshold
+	}
+	return &Offloader{client, entry.Bucket, entry.Prefix, threshold, entry.Always}
+}
+
+// Offload uploads message to S3 when it exceeds the threshold and returns the message to send.
+// Offloaded messages are replaced with a pointer in the AWS extended client library format
+func (o *Offloader) Offload(ctx context.Context, message string) (string, bool, error) {
+	if o == nil || (!o.always && len(message) <= o.threshold) {
+		return message, false, nil
+	}
+	id, err := forwarder.NewID()
+	if err != nil {
+		return "", false, err
+	}
+	key := o.prefix + id
+	params := &s3.PutObjectInput{
+		Bucket: aws.String(o.bucket),
+		Key:    aws.String(key),
+		Body:   strings.NewReader(message),
+	}
+	if _, err = o.s3Client.PutObjectWithContext(ctx, params); err != nil {
+		return "", false, err
+	}
+	log.WithFields(log.Fields{
+		"bucket": o.bucket,
+		"key":    key,
+		"size":   len(message)}).Info("Offloaded message payload")
+	pointer, err := json.Marshal([]interface{}{PointerClass, Pointer{o.bucket, key}})
+	if err != nil {
+		return "", false, err
+	}
+	return string(pointer), true, nil
+}
+
+// PayloadSize original payload size as message attribute value
+func PayloadSize(message string) string {
+	return strconv.Itoa(len(message))
+}
+
+func awsConfig(entry config.OffloadEntry) *aws.Config {
+	cfg := aws.NewConfig().WithS3ForcePathStyle(entry.ForcePathStyle)
+	if entry.Region != "" {
+		cfg = cfg.WithRegion(entry.Region)
+	}
+	if entry.Endpoint != "" {
+		cfg = cfg.WithEndpoint(entry.Endpoint)
+	}
+	return cfg
+}
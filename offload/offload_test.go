@@ -0,0 +1,168 @@
+package offload
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+const bucket = "payloads"
+
+func TestOffload(t *testing.T) {
+	large := strings.Repeat("a", 11)
+	scenarios := []struct {
+		name      string
+		entry     config.OffloadEntry
+		mock      s3iface.S3API
+		message   string
+		offloaded bool
+		err       error
+	}{
+		{
+			name:      "small message",
+			entry:     config.OffloadEntry{Bucket: bucket, Threshold: 10},
+			mock:      &mockAmazonS3{},
+			message:   "abc",
+			offloaded: false,
+		},
+		{
+			name:      "small message always offloaded",
+			entry:     config.OffloadEntry{Bucket: bucket, Threshold: 10, Always: true},
+			mock:      &mockAmazonS3{},
+			message:   "abc",
+			offloaded: true,
+		},
+		{
+			name:      "large message",
+			entry:     config.OffloadEntry{Bucket: bucket, Prefix: "rabbit/", Threshold: 10},
+			mock:      &mockAmazonS3{},
+			message:   large,
+			offloaded: true,
+		},
+		{
+			name:    "upload error",
+			entry:   config.OffloadEntry{Bucket: bucket, Threshold: 10},
+			mock:    &mockAmazonS3{err: errors.New("Access denied")},
+			message: large,
+			err:     errors.New("Access denied"),
+		},
+	}
+	for _, scenario := range scenarios {
+		t.Log("Scenario name: ", scenario.name)
+		offloader := New(scenario.entry, scenario.mock)
+		body, offloaded, err := offloader.Offload(context.Background(), scenario.message)
+		if scenario.err != nil {
+			if err == nil || err.Error() != scenario.err.Error() {
+				t.Errorf("Wrong error, expecting:%v, got:%v", scenario.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Error should not occur. Error: %s", err.Error())
+			continue
+		}
+		if offloaded != scenario.offloaded {
+			t.Errorf("wrong offload result, expected:%t, got:%t", scenario.offloaded, offloaded)
+		}
+		if !offloaded {
+			if body != scenario.message {
+				t.Errorf("message should not be changed, got:%s", body)
+			}
+			continue
+		}
+		mock := scenario.mock.(*mockAmazonS3)
+		pointer := parsePointer(t, body)
+		if pointer.Bucket != bucket || pointer.Key != mock.key || !strings.HasPrefix(pointer.Key, scenario.entry.Prefix) {
+			t.Errorf("wrong pointer, got:%s", body)
+		}
+		if mock.body != scenario.message {
+			t.Errorf("wrong uploaded payload, expected:%s, got:%s", scenario.message, mock.body)
+		}
+	}
+}
+
+func TestOffloadWithoutOffloader(t *testing.T) {
+	var offloader *Offloader
+	body, offloaded, err := offloader.Offload(context.Background(), "abc")
+	if err != nil || offloaded || body != "abc" {
+		t.Errorf("message should not be offloaded, got:%s", body)
+	}
+}
+
+func TestOffloadToS3Endpoint(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "access_key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret_key")
+	uploaded := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		uploaded[r.URL.Path] = string(body)
+	}))
+	defer server.Close()
+
+	entry := config.OffloadEntry{Bucket: bucket, Threshold: 1, Region: "eu-west-1", Endpoint: server.URL, ForcePathStyle: true}
+	body, offloaded, err := New(entry).Offload(context.Background(), "abc")
+	if err != nil {
+		t.Fatalf("Error should not occur. Error: %s", err.Error())
+	}
+	if !offloaded {
+		t.Fatalf("message should be offloaded")
+	}
+	pointer := parsePointer(t, body)
+	if uploaded["/"+bucket+"/"+pointer.Key] != "abc" {
+		t.Errorf("payload not uploaded to S3 endpoint, got:%v", uploaded)
+	}
+}
+
+func parsePointer(t *testing.T, body string) Pointer {
+	var raw []json.RawMessage
+	if err := json.Unmarshal([]byte(body), &raw); err != nil || len(raw) != 2 {
+		t.Fatalf("wrong pointer format: %s", body)
+	}
+	var class string
+	var pointer Pointer
+	if err := json.Unmarshal(raw[0], &class); err != nil || class != PointerClass {
+		t.Fatalf("wrong pointer class: %s", body)
+	}
+	if err := json.Unmarshal(raw[1], &pointer); err != nil {
+		t.Fatalf("wrong pointer: %s", body)
+	}
+	return pointer
+}
+
+type mockAmazonS3 struct {
+	s3iface.S3API
+	key  string
+	body string
+	err  error
+}
+
+func (m *mockAmazonS3) PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	if *input.Bucket != bucket {
+		return nil, errors.New("Wrong bucket name")
+	}
+	body, err := ioutil.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	m.key = *input.Key
+	m.body = string(body)
+	return &s3.PutObjectOutput{}, nil
+}
@@ -0,0 +1,67 @@
+package rabbitmq
+
+import (
+	"context"
+	"time"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
+	"github.com/streadway/amqp"
+)
+
+// batch deliveries waiting to be forwarded by batch forwarder
+type batch struct {
+	client     forwarder.BatchClient
+	deliveries []amqp.Delivery
+	messages   []forwarder.Message
+	timer      *time.Timer
+}
+
+// newBatch creates batch if forwarder supports batching
+func newBatch(client forwarder.Client) *batch {
+	batchClient, ok := client.(forwarder.BatchClient)
+	if !ok {
+		return nil
+	}
+	return &batch{client: batchClient}
+}
+
+// add adds delivery to the batch and returns true if batch is full
+func (b *batch) add(d amqp.Delivery, message forwarder.Message) bool {
+	if len(b.deliveries) == 0 {
+		b.timer = time.NewTimer(b.client.BatchInterval())
+	}
+	b.deliveries = append(b.deliveries, d)
+	b.messages = append(b.messages, message)
+	return len(b.deliveries) >= b.client.BatchSize()
+}
+
+// timeout channel fired when batch interval passes, nil if batch is empty
+func (b *batch) timeout() <-chan time.Time {
+	if b == nil || b.timer == nil {
+		return nil
+	}
+	return b.timer.C
+}
+
+// flush forwards pending messages and returns deliveries with corresponding errors
+func (b *batch) flush(ctx context.Context) ([]amqp.Delivery, []error) {
+	if b == nil || len(b.deliveries) == 0 {
+		return nil, nil
+	}
+	deliveries, messages := b.deliveries, b.messages
+	b.stop()
+	return deliveries, b.client.PushBatch(ctx, messages)
+}
+
+// stop drops pending deliveries, they are redelivered by RabbitMQ
+func (b *batch) stop() {
+	if b == nil {
+		return
+	}
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.deliveries = nil
+	b.messages = nil
+}
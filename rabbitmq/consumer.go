@@ -1,6 +1,7 @@
 package rabbitmq
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"time"
@@ -10,27 +11,38 @@ import (
 	"github.com/AirHelp/rabbit-amazon-forwarder/config"
 	"github.com/AirHelp/rabbit-amazon-forwarder/connector"
 	"github.com/AirHelp/rabbit-amazon-forwarder/consumer"
+	"github.com/AirHelp/rabbit-amazon-forwarder/dedup"
+	"github.com/AirHelp/rabbit-amazon-forwarder/filter"
 	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
+	"github.com/AirHelp/rabbit-amazon-forwarder/transform"
 	"github.com/streadway/amqp"
 )
 
 const (
 	// Type consumer type
-	Type                      = "RabbitMQ"
-	channelClosedMessage      = "Channel closed"
-	closedBySupervisorMessage = "Closed by supervisor"
-	// ReconnectRabbitMQInterval time to reconnect
-	ReconnectRabbitMQInterval = 10
+	Type                       = "RabbitMQ"
+	channelClosedMessage       = "Channel closed"
+	connectionClosedMessage    = "Connection closed"
+	closedBySupervisorMessage  = "Closed by supervisor"
+	certificatesChangedMessage = "Certificates changed"
 )
 
 // Consumer implementation or RabbitMQ consumer
 type Consumer struct {
 	name            string
-	ConnectionURL   string
+	ConnectionURLs  []string
+	Shuffle         bool
 	ExchangeName    string
 	QueueName       string
 	RoutingKeys     []string
 	RabbitConnector connector.RabbitConnector
+	Transformer     *transform.Transformer
+	Filter          *filter.Filter
+	Delivery        string
+	Dedup           *dedup.Deduplicator
+	topology        *topology
+	backoff         backoff
+	state           *connectionState
 }
 
 // parameters for starting consumer
@@ -40,7 +52,28 @@ type workerParams struct {
 	check     chan bool
 	stop      chan bool
 	conn      *amqp.Connection
-	ch        *amqp.Channel
+	ch        consumeChannel
+	closed    chan *amqp.Error
+	blocked   chan amqp.Blocking
+	// certificates notified when TLS certificates are rotated
+	certificates <-chan struct{}
+}
+
+// consumeChannel channel operations used while forwarding deliveries, implemented by amqp.Channel
+type consumeChannel interface {
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	Cancel(consumer string, noWait bool) error
+	Close() error
+}
+
+// closeRabbitMQ closes channel and connection of the worker
+func (p *workerParams) closeRabbitMQ() {
+	if p.ch != nil {
+		if err := p.ch.Close(); err != nil {
+			log.WithField("error", err.Error()).Error("Could not close channel")
+		}
+	}
+	closeRabbitMQ(p.conn, nil)
 }
 
 // CreateConsumer creates consumer from string map
@@ -49,7 +82,55 @@ func CreateConsumer(entry config.RabbitEntry, rabbitConnector connector.RabbitCo
 	if entry.RoutingKey != "" {
 		entry.RoutingKeys = append(entry.RoutingKeys, entry.RoutingKey)
 	}
-	return Consumer{entry.Name, entry.ConnectionURL, entry.ExchangeName, entry.QueueName, entry.RoutingKeys, rabbitConnector}
+	var transformer *transform.Transformer
+	if entry.Transform != nil {
+		var err error
+		if transformer, err = transform.New(*entry.Transform); err != nil {
+			log.WithFields(log.Fields{
+				"consumerName": entry.Name,
+				"error":        err.Error()}).Fatal("Could not create message transformer")
+		}
+	}
+	var messageFilter *filter.Filter
+	if len(entry.Filter) > 0 {
+		var err error
+		if messageFilter, err = filter.New(entry.Filter); err != nil {
+			log.WithFields(log.Fields{
+				"consumerName": entry.Name,
+				"error":        err.Error()}).Fatal("Could not create message filter")
+		}
+	}
+	var deduplicator *dedup.Deduplicator
+	if entry.Dedup != nil {
+		var err error
+		if deduplicator, err = dedup.New(entry.Name, *entry.Dedup); err != nil {
+			log.WithFields(log.Fields{
+				"consumerName": entry.Name,
+				"error":        err.Error()}).Fatal("Could not create message deduplicator")
+		}
+	}
+	delivery, err := consumer.DeliveryMode(entry.Delivery)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"consumerName": entry.Name,
+			"error":        err.Error()}).Fatal("Invalid delivery mode")
+	}
+	reconnect, err := newBackoff(entry.Reconnect)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"consumerName": entry.Name,
+			"error":        err.Error()}).Fatal("Invalid reconnect settings")
+	}
+	queueTopology, err := newTopology(entry)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"consumerName": entry.Name,
+			"error":        err.Error()}).Fatal("Invalid topology settings")
+	}
+	if queueTopology.queueType == StreamQueue {
+		log.WithField("consumerName", entry.Name).Fatalf("Streams are consumed by %s source", StreamType)
+	}
+	return Consumer{entry.Name, entry.ConnectionURLs, entry.Shuffle, entry.ExchangeName, entry.QueueName, entry.RoutingKeys, rabbitConnector, transformer, messageFilter, delivery, deduplicator, queueTopology, *reconnect, &connectionState{}}
 }
 
 // Name consumer name
@@ -57,23 +138,57 @@ func (c Consumer) Name() string {
 	return c.name
 }
 
+// Status reports delivery mode, number of filtered out and duplicated messages and connection state
+func (c Consumer) Status() consumer.Status {
+	status := consumer.Status{Delivery: c.Delivery, DroppedMessages: c.Filter.Dropped(), DuplicateMessages: c.Dedup.Skipped()}
+	if c.state != nil {
+		connection := c.state.get()
+		status.Connection = &connection
+	}
+	return status
+}
+
 // Start start consuming messages from Rabbit queue
-func (c Consumer) Start(forwarder forwarder.Client, check chan bool, stop chan bool) error {
+func (c Consumer) Start(ctx context.Context, forwarder forwarder.Client, check chan bool, stop chan bool) error {
 	log.WithFields(log.Fields{
 		"exchangeName": c.ExchangeName,
-		"queueName":    c.QueueName}).Info("Starting connecting consumer")
+		"queueName":    c.QueueName,
+		"delivery":     c.Delivery}).Info("Starting connecting consumer")
+	reconnect := c.backoff
 	for {
 		delivery, conn, ch, err := c.initRabbitMQ()
 		if err != nil {
 			log.Error(err)
 			closeRabbitMQ(conn, ch)
-			time.Sleep(ReconnectRabbitMQInterval * time.Second)
+			c.state.disconnected(err)
+			if retry, err := reconnect.wait(c.Name(), c.state, err, stop); !retry {
+				return err
+			}
 			continue
 		}
-		params := workerParams{forwarder, delivery, check, stop, conn, ch}
-		if err := c.startForwarding(&params); err.Error() == closedBySupervisorMessage {
+		reconnect.reset()
+		c.state.connected()
+		closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+		blocked := conn.NotifyBlocked(make(chan amqp.Blocking, 1))
+		params := workerParams{forwarder, delivery, check, stop, conn, ch, closed, blocked, c.certificatesChanged()}
+		err = c.startForwarding(ctx, &params)
+		if err.Error() == closedBySupervisorMessage {
 			break
 		}
+		c.state.disconnected(err)
+	}
+	return nil
+}
+
+// certificatesChanged channel notified when connector certificates are rotated, nil if connector does not watch them
+func (c Consumer) certificatesChanged() <-chan struct{} {
+	return watchCertificates(c.RabbitConnector)
+}
+
+// watchCertificates channel notified when connector certificates are rotated
+func watchCertificates(rabbitConnector connector.RabbitConnector) <-chan struct{} {
+	if watcher, ok := rabbitConnector.(connector.CertificateWatcher); ok {
+		return watcher.CertificatesChanged()
 	}
 	return nil
 }
@@ -102,7 +217,7 @@ func (c Consumer) initRabbitMQ() (<-chan amqp.Delivery, *amqp.Connection, *amqp.
 }
 
 func (c Consumer) connect() (<-chan amqp.Delivery, *amqp.Connection, *amqp.Channel, error) {
-	conn, err := c.RabbitConnector.CreateConnection(c.ConnectionURL)
+	conn, err := connector.Dial(c.RabbitConnector, c.ConnectionURLs, c.Shuffle)
 	if err != nil {
 		return failOnError(err, "Failed to connect to RabbitMQ")
 	}
@@ -114,88 +229,303 @@ func (c Consumer) connect() (<-chan amqp.Delivery, *amqp.Connection, *amqp.Chann
 }
 
 func (c Consumer) setupExchangesAndQueues(conn *amqp.Connection, ch *amqp.Channel) (<-chan amqp.Delivery, *amqp.Connection, *amqp.Channel, error) {
-	var err error
-	deadLetterExchangeName := c.QueueName + "-dead-letter"
-	deadLetterQueueName := c.QueueName + "-dead-letter"
-	// regular exchange
-	if err = ch.ExchangeDeclare(c.ExchangeName, "topic", true, false, false, false, nil); err != nil {
-		return failOnError(err, "Failed to declare an exchange:"+c.ExchangeName)
-	}
-	// dead-letter-exchange
-	if err = ch.ExchangeDeclare(deadLetterExchangeName, "fanout", true, false, false, false, nil); err != nil {
-		return failOnError(err, "Failed to declare an exchange:"+deadLetterExchangeName)
+	if err := c.topology.declare(ch); err != nil {
+		return nil, nil, nil, err
 	}
-	// dead-letter-queue
-	if _, err = ch.QueueDeclare(deadLetterQueueName, true, false, false, false, nil); err != nil {
-		return failOnError(err, "Failed to declare a queue:"+deadLetterQueueName)
-	}
-	if err = ch.QueueBind(deadLetterQueueName, "#", deadLetterExchangeName, false, nil); err != nil {
-		return failOnError(err, "Failed to bind a queue:"+deadLetterQueueName)
-	}
-	// regular queue
-	if _, err = ch.QueueDeclare(c.QueueName, true, false, false, false,
-		amqp.Table{
-			"x-dead-letter-exchange": deadLetterExchangeName,
-		}); err != nil {
-		return failOnError(err, "Failed to declare a queue:"+c.QueueName)
-	}
-	// bind all of the routing keys
-	for _, routingKey := range c.RoutingKeys {
-		if err = ch.QueueBind(c.QueueName, routingKey, c.ExchangeName, false, nil); err != nil {
-			return failOnError(err, "Failed to bind a queue:"+c.QueueName)
-		}
-	}
-
-	msgs, err := ch.Consume(c.QueueName, c.Name(), false, false, false, false, nil)
+	autoAck := c.Delivery == consumer.AtMostOnce
+	msgs, err := ch.Consume(c.QueueName, c.Name(), autoAck, false, false, false, nil)
 	if err != nil {
 		return failOnError(err, "Failed to register a consumer")
 	}
 	return msgs, nil, nil, nil
 }
 
-func (c Consumer) startForwarding(params *workerParams) error {
+// startForwarding forwards messages until connection fails or the consumer is stopped,
+// message being forwarded on stop is forwarded and acknowledged before closing
+func (c Consumer) startForwarding(ctx context.Context, params *workerParams) error {
 	forwarderName := params.forwarder.Name()
 	log.WithFields(log.Fields{
 		"consumerName":  c.Name(),
 		"forwarderName": forwarderName}).Info("Started forwarding messages")
+	pending := newBatch(params.forwarder)
+	waiting := &delayed{}
 	for {
 		select {
 		case d, ok := <-params.msgs:
 			if !ok { // channel already closed
-				closeRabbitMQ(params.conn, params.ch)
+				pending.stop()
+				waiting.stop()
+				params.closeRabbitMQ()
 				return errors.New(channelClosedMessage)
 			}
+			message := newMessage(d)
+			message.Reply = newReplier(params.ch, d)
+			if !c.Filter.Match(message) {
+				log.WithFields(log.Fields{
+					"consumerName":    c.Name(),
+					"messageID":       d.MessageId,
+					"routingKey":      d.RoutingKey,
+					"droppedMessages": c.Filter.Dropped()}).Debug("Message filtered out")
+				if err := c.acknowledge(d, nil, forwarderName); err != nil {
+					return err
+				}
+				continue
+			}
+			key := c.Dedup.Key(message)
+			if c.Dedup.Duplicate(key, d.Redelivered) {
+				log.WithFields(log.Fields{
+					"consumerName":      c.Name(),
+					"messageID":         d.MessageId,
+					"redelivered":       d.Redelivered,
+					"duplicateMessages": c.Dedup.Skipped()}).Info("Duplicate message skipped")
+				if err := c.acknowledge(d, nil, forwarderName); err != nil {
+					return err
+				}
+				continue
+			}
 			log.WithFields(log.Fields{
 				"consumerName": c.Name(),
 				"messageID":    d.MessageId}).Info("Message to forward")
-			err := params.forwarder.Push(string(d.Body))
+			body, err := c.Transformer.Apply(message)
 			if err != nil {
 				log.WithFields(log.Fields{
-					"forwarderName": forwarderName,
-					"error":         err.Error()}).Error("Could not forward message")
-				if err = d.Reject(false); err != nil {
-					log.WithFields(log.Fields{
-						"forwarderName": forwarderName,
-						"error":         err.Error()}).Error("Could not reject message")
+					"consumerName": c.Name(),
+					"error":        err.Error()}).Error("Could not transform message")
+				if err = c.acknowledge(d, err, forwarderName); err != nil {
 					return err
 				}
-
-			} else {
-				if err := d.Ack(true); err != nil {
-					log.WithFields(log.Fields{
-						"forwarderName": forwarderName,
-						"error":         err.Error(),
-						"messageID":     d.MessageId}).Error("Could not ack message")
-					return err
+				continue
+			}
+			message.Body = body
+			if pending != nil {
+				if pending.add(d, message) {
+					if err = c.flush(ctx, pending, waiting, forwarderName); err != nil {
+						return err
+					}
 				}
+				continue
+			}
+			err = params.forwarder.Push(ctx, message)
+			if ctx.Err() != nil {
+				// push interrupted by shutdown timeout, the message is requeued when channel is closed
+				return c.close(ctx, params, pending, waiting, forwarderName)
+			}
+			if err == nil {
+				c.Dedup.Mark(key)
+			}
+			if delay := c.requeueWait(d, err); delay > 0 {
+				waiting.add(d, err, delay)
+				continue
+			}
+			if err = c.acknowledge(d, err, forwarderName); err != nil {
+				return err
+			}
+		case <-waiting.timeout():
+			deliveries, errs := waiting.ready(time.Now())
+			if err := c.acknowledgeAll(deliveries, errs, forwarderName); err != nil {
+				return err
+			}
+		case amqpErr, ok := <-params.closed:
+			pending.stop()
+			waiting.stop()
+			params.closeRabbitMQ()
+			if !ok || amqpErr == nil {
+				return errors.New(connectionClosedMessage)
+			}
+			log.WithFields(log.Fields{
+				"consumerName": c.Name(),
+				"error":        amqpErr.Error()}).Error("RabbitMQ connection closed")
+			return fmt.Errorf("%s: %s", connectionClosedMessage, amqpErr.Error())
+		case <-params.certificates:
+			log.WithField("consumerName", c.Name()).Info("Reconnecting with rotated certificates")
+			if err := c.flush(ctx, pending, waiting, forwarderName); err != nil {
+				return err
+			}
+			deliveries, errs := waiting.flush()
+			if err := c.acknowledgeAll(deliveries, errs, forwarderName); err != nil {
+				return err
+			}
+			params.closeRabbitMQ()
+			return errors.New(certificatesChangedMessage)
+		case b, ok := <-params.blocked:
+			if !ok {
+				params.blocked = nil
+				continue
+			}
+			c.state.blocked(b.Active)
+			if b.Active {
+				log.WithFields(log.Fields{
+					"consumerName": c.Name(),
+					"reason":       b.Reason}).Warn("RabbitMQ connection blocked")
+			} else {
+				log.WithField("consumerName", c.Name()).Info("RabbitMQ connection unblocked")
+			}
+		case <-pending.timeout():
+			if err := c.flush(ctx, pending, waiting, forwarderName); err != nil {
+				return err
 			}
 		case <-params.check:
 			log.WithField("forwarderName", forwarderName).Info("Checking")
 		case <-params.stop:
-			log.WithField("forwarderName", forwarderName).Info("Closing")
-			closeRabbitMQ(params.conn, params.ch)
-			return errors.New(closedBySupervisorMessage)
+			return c.close(ctx, params, pending, waiting, forwarderName)
+		}
+	}
+}
+
+// close cancels consuming when supervisor stops the consumer, pending batch is forwarded,
+// delayed messages are requeued without waiting and unacknowledged prefetched messages
+// are requeued when channel is closed
+func (c Consumer) close(ctx context.Context, params *workerParams, pending *batch, waiting *delayed, forwarderName string) error {
+	log.WithField("forwarderName", forwarderName).Info("Closing")
+	if err := params.ch.Cancel(c.Name(), false); err != nil {
+		log.WithFields(log.Fields{
+			"consumerName": c.Name(),
+			"error":        err.Error()}).Error("Could not cancel consumer")
+	}
+	if err := c.flush(ctx, pending, waiting, forwarderName); err != nil {
+		log.WithField("forwarderName", forwarderName).Error("Could not acknowledge pending messages")
+	}
+	deliveries, errs := waiting.flush()
+	if err := c.acknowledgeAll(deliveries, errs, forwarderName); err != nil {
+		log.WithField("forwarderName", forwarderName).Error("Could not requeue delayed messages")
+	}
+	params.closeRabbitMQ()
+	return errors.New(closedBySupervisorMessage)
+}
+
+// flush forwards pending batch and acknowledges each of its messages,
+// messages to requeue wait for their delay
+func (c Consumer) flush(ctx context.Context, pending *batch, waiting *delayed, forwarderName string) error {
+	deliveries, errs := pending.flush(ctx)
+	for i, d := range deliveries {
+		if errs[i] == nil {
+			c.Dedup.Mark(c.Dedup.Key(newMessage(d)))
+		}
+		if delay := c.requeueWait(d, errs[i]); delay > 0 {
+			waiting.add(d, errs[i], delay)
+			continue
+		}
+		if err := c.acknowledge(d, errs[i], forwarderName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// acknowledgeAll acknowledges each of the deliveries with its forwarding error
+func (c Consumer) acknowledgeAll(deliveries []amqp.Delivery, errs []error, forwarderName string) error {
+	for i, d := range deliveries {
+		if err := c.acknowledge(d, errs[i], forwarderName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// acknowledge acks forwarded message or rejects it to dead-letter queue when forwarding failed.
+// With delivery limit of quorum queue transient failures are requeued, the broker counts deliveries
+// and dead-letters the message after the limit. Only the delivery itself is acknowledged,
+// in at-most-once mode messages were already acknowledged by the broker
+func (c Consumer) acknowledge(d amqp.Delivery, err error, forwarderName string) error {
+	if err != nil {
+		log.WithFields(log.Fields{
+			"forwarderName": forwarderName,
+			"error":         err.Error()}).Error("Could not forward message")
+		if c.Delivery == consumer.AtMostOnce {
+			return nil
+		}
+		if c.requeued(err) {
+			log.WithFields(log.Fields{
+				"forwarderName": forwarderName,
+				"messageID":     d.MessageId,
+				"deliveryCount": d.Headers["x-delivery-count"]}).Warn("Requeuing message after transient failure")
+			if err = d.Nack(false, true); err != nil {
+				log.WithFields(log.Fields{
+					"forwarderName": forwarderName,
+					"error":         err.Error()}).Error("Could not requeue message")
+				return err
+			}
+			return nil
 		}
+		if err = d.Reject(false); err != nil {
+			log.WithFields(log.Fields{
+				"forwarderName": forwarderName,
+				"error":         err.Error()}).Error("Could not reject message")
+			return err
+		}
+		return nil
+	}
+	if c.Delivery == consumer.AtMostOnce {
+		return nil
+	}
+	if err := d.Ack(false); err != nil {
+		log.WithFields(log.Fields{
+			"forwarderName": forwarderName,
+			"error":         err.Error(),
+			"messageID":     d.MessageId}).Error("Could not ack message")
+		return err
+	}
+	return nil
+}
+
+// requeued checks if failed message is requeued instead of dead-lettered
+func (c Consumer) requeued(err error) bool {
+	return err != nil && c.Delivery != consumer.AtMostOnce && c.topology.requeue() && forwarder.IsRetryable(err)
+}
+
+// requeueWait delay before failed message is requeued, so a throttling or unavailable destination
+// is not retried in a tight loop. Zero when the message is acknowledged or rejected
+func (c Consumer) requeueWait(d amqp.Delivery, err error) time.Duration {
+	if !c.requeued(err) {
+		return 0
+	}
+	return c.topology.requeueWait(deliveryCount(d))
+}
+
+// deliveryCount number of previous deliveries counted by quorum queue, missing on the first delivery
+func deliveryCount(d amqp.Delivery) int64 {
+	switch count := d.Headers["x-delivery-count"].(type) {
+	case int64:
+		return count
+	case int32:
+		return int64(count)
+	case int:
+		return int64(count)
+	}
+	return 0
+}
+
+func newMessage(d amqp.Delivery) forwarder.Message {
+	timestamp := d.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	return forwarder.Message{
+		Body:          string(d.Body),
+		RoutingKey:    d.RoutingKey,
+		Exchange:      d.Exchange,
+		MessageID:     d.MessageId,
+		CorrelationID: d.CorrelationId,
+		ReplyTo:       d.ReplyTo,
+		ContentType:   d.ContentType,
+		Timestamp:     timestamp,
+		Headers:       d.Headers,
+	}
+}
+
+// newReplier publishes replies to ReplyTo queue of the delivery with its correlation id
+func newReplier(ch consumeChannel, d amqp.Delivery) forwarder.Replier {
+	if d.ReplyTo == "" {
+		return nil
+	}
+	return func(body []byte, headers map[string]interface{}) error {
+		return ch.Publish("", d.ReplyTo, false, false, amqp.Publishing{
+			Headers:       headers,
+			ContentType:   d.ContentType,
+			CorrelationId: d.CorrelationId,
+			Timestamp:     time.Now(),
+			Body:          body,
+		})
 	}
 }
 
@@ -0,0 +1,305 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/streadway/amqp"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
+	"github.com/AirHelp/rabbit-amazon-forwarder/consumer"
+	"github.com/AirHelp/rabbit-amazon-forwarder/dedup"
+	"github.com/AirHelp/rabbit-amazon-forwarder/filter"
+	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
+)
+
+func TestAcknowledge(t *testing.T) {
+	quorum, _ := newTopology(config.RabbitEntry{QueueName: "queue", Topology: &config.TopologyEntry{QueueType: "quorum", DeliveryLimit: 3}})
+	classic, _ := newTopology(config.RabbitEntry{QueueName: "queue"})
+	scenarios := []struct {
+		name     string
+		topology *topology
+		delivery string
+		err      error
+		expected string
+	}{
+		{name: "forwarded", topology: quorum, delivery: consumer.AtLeastOnce, err: nil, expected: "ack"},
+		{name: "failure of classic queue", topology: classic, delivery: consumer.AtLeastOnce, err: forwarder.RetryableError{Err: errors.New("503")}, expected: "reject"},
+		{name: "permanent failure", topology: quorum, delivery: consumer.AtLeastOnce, err: errors.New("invalid message"), expected: "reject"},
+		{name: "retryable failure", topology: quorum, delivery: consumer.AtLeastOnce, err: forwarder.RetryableError{Err: errors.New("503")}, expected: "requeue"},
+		{name: "timeout", topology: quorum, delivery: consumer.AtLeastOnce, err: fmt.Errorf("push: %w", context.DeadlineExceeded), expected: "requeue"},
+		{name: "AWS throttling", topology: quorum, delivery: consumer.AtLeastOnce, err: awserr.New("ThrottlingException", "Rate exceeded", nil), expected: "requeue"},
+		{name: "AWS validation error", topology: quorum, delivery: consumer.AtLeastOnce, err: awserr.New("InvalidParameter", "Invalid parameter", nil), expected: "reject"},
+		{name: "at-most-once failure", topology: quorum, delivery: consumer.AtMostOnce, err: forwarder.RetryableError{Err: errors.New("503")}, expected: ""},
+	}
+	for _, scenario := range scenarios {
+		t.Log("Scenario name: ", scenario.name)
+		acknowledger := &mockAcknowledger{}
+		c := Consumer{Delivery: scenario.delivery, topology: scenario.topology}
+		if err := c.acknowledge(amqp.Delivery{Acknowledger: acknowledger, DeliveryTag: 5}, scenario.err, "forwarder"); err != nil {
+			t.Errorf("acknowledge should not fail, got error: %s", err.Error())
+		}
+		if acknowledger.result != scenario.expected {
+			t.Errorf("wrong acknowledgement, expected: %q, got: %q", scenario.expected, acknowledger.result)
+		}
+		if scenario.expected != "" && (acknowledger.tag != 5 || acknowledger.multiple) {
+			t.Errorf("only the delivery should be acknowledged, got tag: %d, multiple: %t", acknowledger.tag, acknowledger.multiple)
+		}
+	}
+}
+
+func TestStartForwarding(t *testing.T) {
+	equals := "orders"
+	messageFilter, _ := filter.New([]config.FilterEntry{{Field: "routingKey", Equals: &equals}})
+	deduplicator, _ := dedup.New("consumer", config.DedupEntry{})
+	c := createTestConsumer()
+	c.Filter = messageFilter
+	c.Dedup = deduplicator
+	client := &mockForwarder{errs: map[string]error{"invalid": errors.New("invalid message")}}
+	msgs := make(chan amqp.Delivery)
+	params := &workerParams{forwarder: client, msgs: msgs, check: make(chan bool), stop: make(chan bool), ch: &mockConsumeChannel{}}
+	scenarios := []struct {
+		name       string
+		routingKey string
+		messageID  string
+		body       string
+		expected   string
+	}{
+		{name: "forwarded", routingKey: "orders", messageID: "1", body: "abc", expected: "ack"},
+		{name: "failed", routingKey: "orders", messageID: "2", body: "invalid", expected: "reject"},
+		{name: "filtered out", routingKey: "payments", messageID: "3", body: "abc", expected: "ack"},
+		{name: "duplicate", routingKey: "orders", messageID: "1", body: "abc", expected: "ack"},
+	}
+	done := make(chan error)
+	go func() { done <- c.startForwarding(context.Background(), params) }()
+	acknowledgers := make([]*mockAcknowledger, len(scenarios))
+	for i, scenario := range scenarios {
+		acknowledgers[i] = &mockAcknowledger{}
+		msgs <- amqp.Delivery{Acknowledger: acknowledgers[i], DeliveryTag: uint64(i + 1), RoutingKey: scenario.routingKey, MessageId: scenario.messageID, Body: []byte(scenario.body)}
+	}
+	// received stop orders handling of the last delivery before the assertions
+	params.stop <- true
+	<-done
+	for i, scenario := range scenarios {
+		t.Log("Scenario name: ", scenario.name)
+		acknowledger := acknowledgers[i]
+		if acknowledger.result != scenario.expected {
+			t.Errorf("wrong acknowledgement, expected: %q, got: %q", scenario.expected, acknowledger.result)
+		}
+		if acknowledger.tag != uint64(i+1) || acknowledger.multiple {
+			t.Errorf("only the delivery should be acknowledged, got tag: %d, multiple: %t", acknowledger.tag, acknowledger.multiple)
+		}
+	}
+	if len(client.pushed) != 2 || client.pushed[0] != "abc" || client.pushed[1] != "invalid" {
+		t.Errorf("filtered out and duplicate messages should not be pushed, pushed: %v", client.pushed)
+	}
+	if c.Filter.Dropped() != 1 || c.Dedup.Skipped() != 1 {
+		t.Errorf("wrong number of dropped and duplicate messages, dropped: %d, duplicate: %d", c.Filter.Dropped(), c.Dedup.Skipped())
+	}
+}
+
+func TestRequeueWaitOfConsumer(t *testing.T) {
+	quorum, _ := newTopology(config.RabbitEntry{QueueName: "queue", Topology: &config.TopologyEntry{QueueType: "quorum", DeliveryLimit: 3, RequeueDelay: "2s"}})
+	c := Consumer{Delivery: consumer.AtLeastOnce, topology: quorum}
+	transient := forwarder.RetryableError{Err: errors.New("503")}
+	redelivered := amqp.Delivery{Headers: amqp.Table{"x-delivery-count": int64(1)}}
+
+	if wait := c.requeueWait(amqp.Delivery{}, transient); wait != 2*time.Second {
+		t.Errorf("first delivery should wait configured delay, got %s", wait)
+	}
+	if wait := c.requeueWait(redelivered, transient); wait != 4*time.Second {
+		t.Errorf("redelivered message should wait longer, got %s", wait)
+	}
+	if wait := c.requeueWait(redelivered, errors.New("invalid message")); wait != 0 {
+		t.Errorf("rejected message should not wait, got %s", wait)
+	}
+	if wait := c.requeueWait(redelivered, nil); wait != 0 {
+		t.Errorf("forwarded message should not wait, got %s", wait)
+	}
+}
+
+func TestStopWaitsForPush(t *testing.T) {
+	scenarios := []struct {
+		name     string
+		deadline bool
+		expected string
+	}{
+		{name: "push finished before deadline", deadline: false, expected: "ack"},
+		{name: "push interrupted by deadline", deadline: true, expected: ""},
+	}
+	for _, scenario := range scenarios {
+		t.Log("Scenario name: ", scenario.name)
+		c := createTestConsumer()
+		client := &mockSlowForwarder{started: make(chan struct{}), release: make(chan struct{})}
+		params, msgs, ch := createTestParams(client)
+		ctx, cancel := context.WithCancel(context.Background())
+		acknowledger := &mockAcknowledger{}
+		msgs <- amqp.Delivery{Acknowledger: acknowledger, DeliveryTag: 1, Body: []byte("abc")}
+		done := make(chan error)
+		go func() { done <- c.startForwarding(ctx, params) }()
+		<-client.started
+		stopped := make(chan struct{})
+		go func() {
+			select {
+			case params.stop <- true:
+				close(stopped)
+			case <-time.After(time.Second):
+			}
+		}()
+		select {
+		case <-stopped:
+			t.Errorf("stop should not be received while message is pushed")
+		case <-time.After(50 * time.Millisecond):
+		}
+		if scenario.deadline {
+			cancel()
+		} else {
+			close(client.release)
+		}
+		if err := <-done; err == nil || err.Error() != closedBySupervisorMessage {
+			t.Errorf("consumer should be closed by supervisor, got: %v", err)
+		}
+		if acknowledger.result != scenario.expected {
+			t.Errorf("wrong acknowledgement, expected: %q, got: %q", scenario.expected, acknowledger.result)
+		}
+		if !ch.cancelled || !ch.closed {
+			t.Errorf("consuming should be cancelled and channel closed")
+		}
+		cancel()
+	}
+}
+
+func TestRequeueDelayKeepsServingConsumer(t *testing.T) {
+	scenarios := []struct {
+		name     string
+		delay    string
+		wait     time.Duration
+		expected string
+	}{
+		{name: "requeued after delay", delay: "100ms", wait: 300 * time.Millisecond, expected: "requeue"},
+		{name: "requeued on stop", delay: "30s", wait: 0, expected: ""},
+	}
+	for _, scenario := range scenarios {
+		t.Log("Scenario name: ", scenario.name)
+		quorum, _ := newTopology(config.RabbitEntry{QueueName: "queue", Topology: &config.TopologyEntry{QueueType: "quorum", DeliveryLimit: 3, RequeueDelay: scenario.delay}})
+		c := Consumer{Delivery: consumer.AtLeastOnce, topology: quorum, state: &connectionState{}}
+		client := &mockStreamForwarder{err: forwarder.RetryableError{Err: errors.New("503")}}
+		params, msgs, _ := createTestParams(client)
+		acknowledger := &mockAcknowledger{}
+		msgs <- amqp.Delivery{Acknowledger: acknowledger, DeliveryTag: 1, Body: []byte("abc")}
+		done := make(chan error)
+		go func() { done <- c.startForwarding(context.Background(), params) }()
+		select {
+		case params.check <- true:
+		case <-time.After(checkTimeout):
+			t.Errorf("health check should be received while message waits for requeue")
+		}
+		time.Sleep(scenario.wait)
+		// received check orders acknowledgement of the consumer before the assertion
+		params.check <- true
+		if acknowledger.result != scenario.expected {
+			t.Errorf("wrong acknowledgement before stop, expected: %q, got: %q", scenario.expected, acknowledger.result)
+		}
+		params.stop <- true
+		<-done
+		if acknowledger.result != "requeue" {
+			t.Errorf("message should be requeued, got: %q", acknowledger.result)
+		}
+	}
+}
+
+func createTestConsumer() Consumer {
+	topology, _ := newTopology(config.RabbitEntry{QueueName: "queue"})
+	return Consumer{Delivery: consumer.AtLeastOnce, topology: topology, state: &connectionState{}}
+}
+
+func createTestParams(client forwarder.Client) (*workerParams, chan amqp.Delivery, *mockConsumeChannel) {
+	msgs := make(chan amqp.Delivery, 10)
+	ch := &mockConsumeChannel{}
+	return &workerParams{forwarder: client, msgs: msgs, check: make(chan bool), stop: make(chan bool), ch: ch}, msgs, ch
+}
+
+type mockConsumeChannel struct {
+	cancelled bool
+	closed    bool
+}
+
+func (c *mockConsumeChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	return nil
+}
+
+func (c *mockConsumeChannel) Cancel(consumer string, noWait bool) error {
+	c.cancelled = true
+	return nil
+}
+
+func (c *mockConsumeChannel) Close() error {
+	c.closed = true
+	return nil
+}
+
+// mockSlowForwarder push waits until it is released or its context is cancelled
+type mockSlowForwarder struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (f *mockSlowForwarder) Name() string {
+	return "slow-forwarder"
+}
+
+func (f *mockSlowForwarder) Push(ctx context.Context, message forwarder.Message) error {
+	close(f.started)
+	select {
+	case <-f.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// mockForwarder fails pushes of message bodies with configured errors
+type mockForwarder struct {
+	errs   map[string]error
+	pushed []string
+}
+
+func (f *mockForwarder) Name() string {
+	return "forwarder"
+}
+
+func (f *mockForwarder) Push(ctx context.Context, message forwarder.Message) error {
+	f.pushed = append(f.pushed, message.Body)
+	return f.errs[message.Body]
+}
+
+// checkTimeout time in which supervisor expects consumer to receive health check
+const checkTimeout = 500 * time.Millisecond
+
+type mockAcknowledger struct {
+	result   string
+	tag      uint64
+	multiple bool
+}
+
+func (a *mockAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.result, a.tag, a.multiple = "ack", tag, multiple
+	return nil
+}
+
+func (a *mockAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.result, a.tag, a.multiple = "nack", tag, multiple
+	if requeue {
+		a.result = "requeue"
+	}
+	return nil
+}
+
+func (a *mockAcknowledger) Reject(tag uint64, requeue bool) error {
+	a.result, a.tag = "reject", tag
+	return nil
+}
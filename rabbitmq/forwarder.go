@@ -0,0 +1,220 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
+	"github.com/AirHelp/rabbit-amazon-forwarder/connector"
+	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
+	"github.com/streadway/amqp"
+)
+
+const (
+	// ConfirmTimeout time to wait for publisher confirm
+	ConfirmTimeout = 30 * time.Second
+)
+
+// Forwarder publishes messages to RabbitMQ exchange with publisher confirms
+type Forwarder struct {
+	name            string
+	connectionURLs  []string
+	shuffle         bool
+	exchange        string
+	routingKey      string
+	mandatory       bool
+	rabbitConnector connector.RabbitConnector
+	publisher       *publisher
+	confirmTimeout  time.Duration
+	// dial opens connection and channel in confirm mode
+	dial func() (*amqp.Connection, publishChannel, error)
+}
+
+// publishChannel channel operations used to publish with confirms, implemented by amqp.Channel
+type publishChannel interface {
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation
+	NotifyReturn(c chan amqp.Return) chan amqp.Return
+	NotifyClose(c chan *amqp.Error) chan *amqp.Error
+	Close() error
+}
+
+// publisher connection state shared between copies of the forwarder
+type publisher struct {
+	sync.Mutex
+	conn     *amqp.Connection
+	ch       publishChannel
+	confirms chan amqp.Confirmation
+	returns  chan amqp.Return
+	closed   chan *amqp.Error
+}
+
+// CreateForwarder creates instance of forwarder
+func CreateForwarder(entry config.AmazonEntry, rabbitConnector connector.RabbitConnector) forwarder.Client {
+	forwarder := Forwarder{
+		name:            entry.Name,
+		connectionURLs:  entry.ConnectionURLs,
+		shuffle:         entry.Shuffle,
+		exchange:        entry.Target,
+		routingKey:      entry.RoutingKey,
+		mandatory:       entry.Mandatory,
+		rabbitConnector: rabbitConnector,
+		publisher:       &publisher{},
+		confirmTimeout:  ConfirmTimeout,
+	}
+	forwarder.dial = forwarder.open
+	log.WithField("forwarderName", forwarder.Name()).Info("Created forwarder")
+	return forwarder
+}
+
+// Name forwarder name
+func (f Forwarder) Name() string {
+	return f.name
+}
+
+// Push publishes message with its properties and headers and waits for broker confirm until
+// context is done. Original routing key is used when routing key is not configured
+func (f Forwarder) Push(ctx context.Context, message forwarder.Message) error {
+	if message.Body == "" {
+		return errors.New(forwarder.EmptyMessageError)
+	}
+	routingKey := f.routingKey
+	if routingKey == "" {
+		routingKey = message.RoutingKey
+	}
+	msg := amqp.Publishing{
+		Headers:       amqp.Table(message.Headers),
+		ContentType:   message.ContentType,
+		DeliveryMode:  amqp.Persistent,
+		CorrelationId: message.CorrelationID,
+		ReplyTo:       message.ReplyTo,
+		MessageId:     message.MessageID,
+		Timestamp:     message.Timestamp,
+		Body:          []byte(message.Body),
+	}
+	if err := f.publish(ctx, routingKey, msg); err != nil {
+		log.WithFields(log.Fields{
+			"forwarderName": f.Name(),
+			"error":         err.Error()}).Error("Could not forward message")
+		return err
+	}
+	log.WithFields(log.Fields{
+		"forwarderName": f.Name(),
+		"routingKey":    routingKey}).Info("Forward succeeded")
+	return nil
+}
+
+func (f Forwarder) publish(ctx context.Context, routingKey string, msg amqp.Publishing) error {
+	p := f.publisher
+	p.Lock()
+	defer p.Unlock()
+	if p.ch != nil {
+		select {
+		case err := <-p.closed:
+			log.WithFields(log.Fields{
+				"forwarderName": f.Name(),
+				"error":         err}).Warn("Publisher channel closed, reconnecting")
+			f.reset()
+		case <-f.certificatesChanged():
+			log.WithField("forwarderName", f.Name()).Info("Reconnecting with rotated certificates")
+			f.reset()
+		default:
+		}
+	}
+	if p.ch == nil {
+		if err := f.connect(); err != nil {
+			return err
+		}
+	}
+	if err := p.ch.Publish(f.exchange, routingKey, f.mandatory, false, msg); err != nil {
+		f.reset()
+		return err
+	}
+	select {
+	case confirm, ok := <-p.confirms:
+		if !ok {
+			f.reset()
+			return errors.New("channel closed before publish was confirmed")
+		}
+		// unroutable mandatory message is returned before it is confirmed
+		select {
+		case r := <-p.returns:
+			return fmt.Errorf("message returned by broker: %d %s", r.ReplyCode, r.ReplyText)
+		default:
+		}
+		if !confirm.Ack {
+			return fmt.Errorf("publish to exchange %s not acknowledged by broker", f.exchange)
+		}
+		return nil
+	case <-time.After(f.confirmTimeout):
+		f.reset()
+		return fmt.Errorf("publish confirm not received within %s", f.confirmTimeout)
+	case <-ctx.Done():
+		// confirm of abandoned publish would be taken by the next one
+		f.reset()
+		return ctx.Err()
+	}
+}
+
+// open opens connection and channel in confirm mode
+func (f Forwarder) open() (*amqp.Connection, publishChannel, error) {
+	conn, err := connector.Dial(f.rabbitConnector, f.connectionURLs, f.shuffle)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to connect to RabbitMQ: %s", err)
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("Failed to open a channel: %s", err)
+	}
+	if err = ch.Confirm(false); err != nil {
+		closeRabbitMQ(conn, ch)
+		return nil, nil, fmt.Errorf("Failed to put channel in confirm mode: %s", err)
+	}
+	return conn, ch, nil
+}
+
+// connect opens publisher channel and subscribes to its notifications, caller holds the lock
+func (f Forwarder) connect() error {
+	p := f.publisher
+	conn, ch, err := f.dial()
+	if err != nil {
+		return err
+	}
+	p.conn = conn
+	p.ch = ch
+	p.confirms = ch.NotifyPublish(make(chan amqp.Confirmation, 1))
+	p.returns = ch.NotifyReturn(make(chan amqp.Return, 1))
+	p.closed = ch.NotifyClose(make(chan *amqp.Error, 1))
+	log.WithField("forwarderName", f.Name()).Info("Publisher connected")
+	return nil
+}
+
+// certificatesChanged channel notified when connector certificates are rotated, nil if connector does not watch them
+func (f Forwarder) certificatesChanged() <-chan struct{} {
+	if watcher, ok := f.rabbitConnector.(connector.CertificateWatcher); ok {
+		return watcher.CertificatesChanged()
+	}
+	return nil
+}
+
+// reset drops current connection so the next publish reconnects, caller holds the lock
+func (f Forwarder) reset() {
+	p := f.publisher
+	if p.ch != nil {
+		if err := p.ch.Close(); err != nil {
+			log.WithField("error", err.Error()).Error("Could not close channel")
+		}
+	}
+	closeRabbitMQ(p.conn, nil)
+	p.conn = nil
+	p.ch = nil
+	p.confirms = nil
+	p.returns = nil
+	p.closed = nil
+}
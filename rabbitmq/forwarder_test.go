@@ -0,0 +1,170 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
+	"github.com/AirHelp/rabbit-amazon-forwarder/connector"
+	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
+)
+
+func TestForwarderPush(t *testing.T) {
+	scenarios := []struct {
+		name      string
+		mandatory bool
+		channel   *mockPublishChannel
+		err       string
+		reset     bool
+	}{
+		{name: "confirmed", channel: &mockPublishChannel{confirm: true, ack: true}},
+		{name: "not acknowledged", channel: &mockPublishChannel{confirm: true, ack: false}, err: "publish to exchange exchange not acknowledged by broker"},
+		{name: "returned mandatory message", mandatory: true, channel: &mockPublishChannel{confirm: true, ack: true, returned: true}, err: "message returned by broker: 312 NO_ROUTE"},
+		{name: "confirm timeout", channel: &mockPublishChannel{}, err: "publish confirm not received within 50ms", reset: true},
+		{name: "publish failure", channel: &mockPublishChannel{err: errors.New("channel/connection is not open")}, err: "channel/connection is not open", reset: true},
+	}
+	for _, scenario := range scenarios {
+		t.Log("Scenario name: ", scenario.name)
+		f, dialer := createTestForwarder(scenario.mandatory, scenario.channel)
+
+		err := f.Push(context.Background(), forwarder.Message{Body: "abc", RoutingKey: "user.created"})
+
+		if scenario.err == "" && err != nil {
+			t.Errorf("Error should not occur. Error: %s", err.Error())
+		}
+		if scenario.err != "" && (err == nil || err.Error() != scenario.err) {
+			t.Errorf("Wrong error, expecting:%s, got:%v", scenario.err, err)
+		}
+		if len(scenario.channel.published) != 1 || scenario.channel.published[0] != "exchange user.created abc" {
+			t.Errorf("wrong published messages, got: %v", scenario.channel.published)
+		}
+		if scenario.channel.mandatory != scenario.mandatory {
+			t.Errorf("wrong mandatory flag, expected: %t, got: %t", scenario.mandatory, scenario.channel.mandatory)
+		}
+		if scenario.channel.closedCalls > 0 != scenario.reset {
+			t.Errorf("wrong channel reset, expected: %t, got %d close calls", scenario.reset, scenario.channel.closedCalls)
+		}
+		if dialer.dials != 1 {
+			t.Errorf("wrong number of dials, expected: %d, got: %d", 1, dialer.dials)
+		}
+	}
+}
+
+func TestForwarderReconnect(t *testing.T) {
+	first := &mockPublishChannel{confirm: true, ack: true}
+	second := &mockPublishChannel{confirm: true, ack: true}
+	f, dialer := createTestForwarder(false, first, second)
+
+	if err := f.Push(context.Background(), forwarder.Message{Body: "abc"}); err != nil {
+		t.Fatalf("Error should not occur. Error: %s", err.Error())
+	}
+	if err := f.Push(context.Background(), forwarder.Message{Body: "def"}); err != nil {
+		t.Fatalf("Error should not occur. Error: %s", err.Error())
+	}
+	if dialer.dials != 1 {
+		t.Errorf("open channel should be reused, got %d dials", dialer.dials)
+	}
+
+	first.closed <- amqp.ErrClosed
+	if err := f.Push(context.Background(), forwarder.Message{Body: "ghi"}); err != nil {
+		t.Fatalf("Error should not occur. Error: %s", err.Error())
+	}
+	if dialer.dials != 2 {
+		t.Errorf("closed channel should be reopened, got %d dials", dialer.dials)
+	}
+	if first.closedCalls != 1 || len(first.published) != 2 || len(second.published) != 1 {
+		t.Errorf("message should be published to reopened channel, got %v and %v", first.published, second.published)
+	}
+}
+
+func TestForwarderDialFailure(t *testing.T) {
+	f, dialer := createTestForwarder(false)
+
+	if err := f.Push(context.Background(), forwarder.Message{Body: "abc"}); err == nil || err.Error() != "Failed to connect to RabbitMQ: connection refused" {
+		t.Errorf("dial error should be returned, got: %v", err)
+	}
+	if err := f.Push(context.Background(), forwarder.Message{}); err == nil || err.Error() != forwarder.EmptyMessageError {
+		t.Errorf("empty message should not be published, got: %v", err)
+	}
+	if dialer.dials != 1 {
+		t.Errorf("wrong number of dials, expected: %d, got: %d", 1, dialer.dials)
+	}
+}
+
+// createTestForwarder creates forwarder publishing to exchange, every dial opens next of the channels
+func createTestForwarder(mandatory bool, channels ...*mockPublishChannel) (Forwarder, *mockDialer) {
+	entry := config.AmazonEntry{Type: "RabbitMQ", Name: "rabbitmq-test", Target: "exchange", Mandatory: mandatory, ConnectionURLs: []string{"amqp://localhost"}}
+	f := CreateForwarder(entry, connector.CreateBasicRabbitConnector()).(Forwarder)
+	f.confirmTimeout = 50 * time.Millisecond
+	dialer := &mockDialer{channels: channels}
+	f.dial = dialer.dial
+	return f, dialer
+}
+
+type mockDialer struct {
+	channels []*mockPublishChannel
+	dials    int
+}
+
+func (d *mockDialer) dial() (*amqp.Connection, publishChannel, error) {
+	d.dials++
+	if len(d.channels) == 0 {
+		return nil, nil, errors.New("Failed to connect to RabbitMQ: connection refused")
+	}
+	ch := d.channels[0]
+	d.channels = d.channels[1:]
+	return nil, ch, nil
+}
+
+// mockPublishChannel confirms or returns published messages like the broker
+type mockPublishChannel struct {
+	confirm     bool
+	ack         bool
+	returned    bool
+	err         error
+	mandatory   bool
+	published   []string
+	closedCalls int
+	confirms    chan amqp.Confirmation
+	returns     chan amqp.Return
+	closed      chan *amqp.Error
+}
+
+func (c *mockPublishChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	c.published = append(c.published, exchange+" "+key+" "+string(msg.Body))
+	c.mandatory = mandatory
+	if c.err != nil {
+		return c.err
+	}
+	if c.returned {
+		c.returns <- amqp.Return{ReplyCode: 312, ReplyText: "NO_ROUTE"}
+	}
+	if c.confirm {
+		c.confirms <- amqp.Confirmation{DeliveryTag: uint64(len(c.published)), Ack: c.ack}
+	}
+	return nil
+}
+
+func (c *mockPublishChannel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	c.confirms = confirm
+	return confirm
+}
+
+func (c *mockPublishChannel) NotifyReturn(returns chan amqp.Return) chan amqp.Return {
+	c.returns = returns
+	return returns
+}
+
+func (c *mockPublishChannel) NotifyClose(closed chan *amqp.Error) chan *amqp.Error {
+	c.closed = closed
+	return closed
+}
+
+func (c *mockPublishChannel) Close() error {
+	c.closedCalls++
+	return nil
+}
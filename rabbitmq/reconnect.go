@@ -0,0 +1,145 @@
+package rabbitmq
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
+	"github.com/AirHelp/rabbit-amazon-forwarder/consumer"
+)
+
+const (
+	// DefaultReconnectInterval wait time before the first reconnect attempt
+	DefaultReconnectInterval = time.Second
+	// DefaultMaxReconnectInterval maximum wait time between reconnect attempts
+	DefaultMaxReconnectInterval = time.Minute
+)
+
+// backoff exponential reconnect backoff with jitter
+type backoff struct {
+	interval    time.Duration
+	maxInterval time.Duration
+	maxAttempts int
+	attempts    int
+}
+
+// newBackoff creates backoff from mapping entry, defaults are used for empty values
+func newBackoff(entry *config.ReconnectEntry) (*backoff, error) {
+	b := &backoff{interval: DefaultReconnectInterval, maxInterval: DefaultMaxReconnectInterval}
+	if entry == nil {
+		return b, nil
+	}
+	var err error
+	if entry.Interval != "" {
+		if b.interval, err = time.ParseDuration(entry.Interval); err != nil {
+			return nil, err
+		}
+	}
+	if entry.MaxInterval != "" {
+		if b.maxInterval, err = time.ParseDuration(entry.MaxInterval); err != nil {
+			return nil, err
+		}
+	}
+	if b.interval <= 0 || b.maxInterval < b.interval {
+		return nil, fmt.Errorf("reconnect interval %s has to be positive and not greater than max interval %s", b.interval, b.maxInterval)
+	}
+	if entry.MaxAttempts < 0 {
+		return nil, fmt.Errorf("reconnect max attempts cannot be negative, found %d", entry.MaxAttempts)
+	}
+	b.maxAttempts = entry.MaxAttempts
+	return b, nil
+}
+
+// next returns wait time before the next attempt, doubled with every attempt up to max interval.
+// Random half of the wait time is added so consumers of the same broker do not reconnect together
+func (b *backoff) next() time.Duration {
+	wait := b.maxInterval
+	if b.attempts < 32 {
+		if exp := b.interval << uint(b.attempts); exp > 0 && exp < b.maxInterval {
+			wait = exp
+		}
+	}
+	b.attempts++
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+// exhausted checks if max attempts were used, never when max attempts are not limited
+func (b *backoff) exhausted() bool {
+	return b.maxAttempts > 0 && b.attempts >= b.maxAttempts
+}
+
+func (b *backoff) reset() {
+	b.attempts = 0
+}
+
+// wait waits before the next connection attempt. It returns error when attempts are exhausted
+// and false when consumer is stopped while waiting
+func (b *backoff) wait(name string, state *connectionState, err error, stop chan bool) (bool, error) {
+	if b.exhausted() {
+		state.failed()
+		log.WithFields(log.Fields{
+			"consumerName": name,
+			"attempts":     b.attempts}).Error("Giving up reconnecting to RabbitMQ")
+		return false, fmt.Errorf("could not connect to RabbitMQ after %d attempts: %s", b.attempts, err)
+	}
+	wait := b.next()
+	log.WithFields(log.Fields{
+		"consumerName": name,
+		"attempt":      b.attempts,
+		"wait":         wait.String()}).Info("Reconnecting to RabbitMQ")
+	select {
+	case <-stop:
+		log.WithField("consumerName", name).Info("Closing")
+		return false, nil
+	case <-time.After(wait):
+	}
+	return true, nil
+}
+
+// connectionState connection state shared between copies of the consumer
+type connectionState struct {
+	sync.Mutex
+	status consumer.ConnectionStatus
+}
+
+func (s *connectionState) connected() {
+	s.Lock()
+	defer s.Unlock()
+	s.status.Connected = true
+	s.status.Blocked = false
+	s.status.Failed = false
+	s.status.LastConnected = time.Now()
+}
+
+func (s *connectionState) disconnected(err error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.status.Connected {
+		s.status.Reconnects++
+	}
+	s.status.Connected = false
+	s.status.Blocked = false
+	s.status.LastError = err.Error()
+}
+
+func (s *connectionState) blocked(active bool) {
+	s.Lock()
+	defer s.Unlock()
+	s.status.Blocked = active
+}
+
+func (s *connectionState) failed() {
+	s.Lock()
+	defer s.Unlock()
+	s.status.Failed = true
+}
+
+func (s *connectionState) get() consumer.ConnectionStatus {
+	s.Lock()
+	defer s.Unlock()
+	return s.status
+}
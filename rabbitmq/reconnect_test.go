@@ -0,0 +1,83 @@
+package rabbitmq
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
+)
+
+func TestNewBackoff(t *testing.T) {
+	scenarios := []struct {
+		name  string
+		entry *config.ReconnectEntry
+		valid bool
+	}{
+		{name: "defaults", entry: nil, valid: true},
+		{name: "custom intervals", entry: &config.ReconnectEntry{Interval: "500ms", MaxInterval: "5s", MaxAttempts: 3}, valid: true},
+		{name: "invalid interval", entry: &config.ReconnectEntry{Interval: "second"}, valid: false},
+		{name: "interval greater than max", entry: &config.ReconnectEntry{Interval: "2m", MaxInterval: "1m"}, valid: false},
+		{name: "negative max attempts", entry: &config.ReconnectEntry{MaxAttempts: -1}, valid: false},
+	}
+	for _, scenario := range scenarios {
+		t.Log("Scenario name: ", scenario.name)
+		_, err := newBackoff(scenario.entry)
+		if scenario.valid && err != nil {
+			t.Errorf("backoff should be created, got error: %s", err.Error())
+		}
+		if !scenario.valid && err == nil {
+			t.Errorf("backoff should not be created")
+		}
+	}
+}
+
+func TestBackoffNext(t *testing.T) {
+	b, _ := newBackoff(&config.ReconnectEntry{Interval: "1s", MaxInterval: "8s", MaxAttempts: 6})
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 8 * time.Second}
+	for i, max := range expected {
+		wait := b.next()
+		if wait < max/2 || wait > max {
+			t.Errorf("wrong wait time of attempt %d, expected between %s and %s, got %s", i+1, max/2, max, wait)
+		}
+	}
+	if b.exhausted() {
+		t.Errorf("backoff should not be exhausted after %d attempts", b.attempts)
+	}
+	b.next()
+	if !b.exhausted() {
+		t.Errorf("backoff should be exhausted after %d attempts", b.attempts)
+	}
+	b.reset()
+	if b.exhausted() || b.next() > time.Second {
+		t.Errorf("backoff should start from the beginning after reset")
+	}
+
+	unlimited, _ := newBackoff(nil)
+	for i := 0; i < 100; i++ {
+		if wait := unlimited.next(); wait > DefaultMaxReconnectInterval {
+			t.Errorf("wait time should not exceed max interval, got %s", wait)
+		}
+	}
+	if unlimited.exhausted() {
+		t.Errorf("backoff without max attempts should never be exhausted")
+	}
+}
+
+func TestConnectionState(t *testing.T) {
+	state := &connectionState{}
+	state.disconnected(errors.New("dial error"))
+	if status := state.get(); status.Connected || status.Reconnects != 0 || status.LastError != "dial error" {
+		t.Errorf("wrong status after failed connection: %+v", status)
+	}
+	state.connected()
+	state.blocked(true)
+	if status := state.get(); !status.Connected || !status.Blocked {
+		t.Errorf("wrong status of blocked connection: %+v", status)
+	}
+	state.disconnected(errors.New("connection closed"))
+	state.failed()
+	if status := state.get(); status.Connected || status.Blocked || !status.Failed || status.Reconnects != 1 {
+		t.Errorf("wrong status after connection loss: %+v", status)
+	}
+}
@@ -0,0 +1,91 @@
+package rabbitmq
+
+import (
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// delayed failed deliveries waiting to be requeued, consumer keeps serving health checks,
+// connection events and stop while they wait
+type delayed struct {
+	deliveries []amqp.Delivery
+	errs       []error
+	due        []time.Time
+	timer      *time.Timer
+}
+
+// add schedules requeue of the failed delivery after the delay
+func (r *delayed) add(d amqp.Delivery, err error, delay time.Duration) {
+	r.deliveries = append(r.deliveries, d)
+	r.errs = append(r.errs, err)
+	r.due = append(r.due, time.Now().Add(delay))
+	r.reset()
+}
+
+// timeout channel fired when the earliest delivery is due, nil if no delivery waits
+func (r *delayed) timeout() <-chan time.Time {
+	if r == nil || r.timer == nil {
+		return nil
+	}
+	return r.timer.C
+}
+
+// ready removes deliveries which waited for their delay and returns them with their errors
+func (r *delayed) ready(now time.Time) ([]amqp.Delivery, []error) {
+	var deliveries []amqp.Delivery
+	var errs []error
+	var i int
+	for j, d := range r.deliveries {
+		if !r.due[j].After(now) {
+			deliveries = append(deliveries, d)
+			errs = append(errs, r.errs[j])
+			continue
+		}
+		r.deliveries[i], r.errs[i], r.due[i] = d, r.errs[j], r.due[j]
+		i++
+	}
+	r.deliveries, r.errs, r.due = r.deliveries[:i], r.errs[:i], r.due[:i]
+	r.reset()
+	return deliveries, errs
+}
+
+// flush removes every waiting delivery regardless of its delay
+func (r *delayed) flush() ([]amqp.Delivery, []error) {
+	if r == nil {
+		return nil, nil
+	}
+	deliveries, errs := r.deliveries, r.errs
+	r.stop()
+	return deliveries, errs
+}
+
+// stop drops waiting deliveries, they are redelivered by RabbitMQ
+func (r *delayed) stop() {
+	if r == nil {
+		return
+	}
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+	r.deliveries, r.errs, r.due = nil, nil, nil
+}
+
+// reset sets timer to the earliest due delivery
+func (r *delayed) reset() {
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+	if len(r.due) == 0 {
+		return
+	}
+	earliest := r.due[0]
+	for _, due := range r.due[1:] {
+		if due.Before(earliest) {
+			earliest = due
+		}
+	}
+	r.timer = time.NewTimer(time.Until(earliest))
+}
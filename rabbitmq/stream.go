@@ -0,0 +1,489 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
+	"github.com/AirHelp/rabbit-amazon-forwarder/connector"
+	"github.com/AirHelp/rabbit-amazon-forwarder/consumer"
+	"github.com/AirHelp/rabbit-amazon-forwarder/filter"
+	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
+	"github.com/AirHelp/rabbit-amazon-forwarder/transform"
+)
+
+const (
+	// StreamType stream consumer type
+	StreamType = "RabbitMQStream"
+	// FirstOffset consume stream from the first message
+	FirstOffset = "first"
+	// LastOffset consume stream from the last chunk of messages
+	LastOffset = "last"
+	// NextOffset consume only new messages of the stream
+	NextOffset = "next"
+	// DefaultStreamPrefetch maximum number of unacknowledged stream messages, streams require limited prefetch
+	DefaultStreamPrefetch = 100
+	// DefaultCommitInterval number of forwarded messages after which offset is written to offset file
+	DefaultCommitInterval = 100
+	streamOffsetHeader    = "x-stream-offset"
+)
+
+// StreamConsumer consumer of RabbitMQ stream. Messages stay in the stream after they are forwarded,
+// so the stream can be replayed from any offset to a new destination
+type StreamConsumer struct {
+	name            string
+	connectionURLs  []string
+	shuffle         bool
+	rabbitConnector connector.RabbitConnector
+	topology        *topology
+	transformer     *transform.Transformer
+	filter          *filter.Filter
+	offset          interface{}
+	prefetch        int
+	tracker         *offsetTracker
+	backoff         backoff
+	state           *connectionState
+}
+
+// CreateStreamConsumer creates stream consumer from mapping entry
+func CreateStreamConsumer(entry config.RabbitEntry, rabbitConnector connector.RabbitConnector) consumer.Client {
+	if entry.RoutingKey != "" {
+		entry.RoutingKeys = append(entry.RoutingKeys, entry.RoutingKey)
+	}
+	var transformer *transform.Transformer
+	if entry.Transform != nil {
+		var err error
+		if transformer, err = transform.New(*entry.Transform); err != nil {
+			log.WithFields(log.Fields{
+				"consumerName": entry.Name,
+				"error":        err.Error()}).Fatal("Could not create message transformer")
+		}
+	}
+	var messageFilter *filter.Filter
+	if len(entry.Filter) > 0 {
+		var err error
+		if messageFilter, err = filter.New(entry.Filter); err != nil {
+			log.WithFields(log.Fields{
+				"consumerName": entry.Name,
+				"error":        err.Error()}).Fatal("Could not create message filter")
+		}
+	}
+	var settings config.StreamEntry
+	if entry.Stream != nil {
+		settings = *entry.Stream
+	}
+	offset, err := parseOffset(settings.Offset, time.Now())
+	if err != nil {
+		log.WithFields(log.Fields{
+			"consumerName": entry.Name,
+			"error":        err.Error()}).Fatal("Invalid stream offset")
+	}
+	if settings.Prefetch < 0 || settings.CommitInterval < 0 {
+		log.WithFields(log.Fields{
+			"consumerName":   entry.Name,
+			"prefetch":       settings.Prefetch,
+			"commitInterval": settings.CommitInterval}).Fatal("Stream prefetch and commit interval cannot be negative")
+	}
+	if settings.Prefetch == 0 {
+		settings.Prefetch = DefaultStreamPrefetch
+	}
+	if settings.CommitInterval == 0 {
+		settings.CommitInterval = DefaultCommitInterval
+	}
+	var topologySettings config.TopologyEntry
+	if entry.Topology != nil {
+		topologySettings = *entry.Topology
+	}
+	if topologySettings.QueueType != "" && topologySettings.QueueType != StreamQueue {
+		log.WithFields(log.Fields{
+			"consumerName": entry.Name,
+			"queueType":    topologySettings.QueueType}).Fatal("Stream consumer requires stream queue")
+	}
+	topologySettings.QueueType = StreamQueue
+	entry.Topology = &topologySettings
+	streamTopology, err := newTopology(entry)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"consumerName": entry.Name,
+			"error":        err.Error()}).Fatal("Invalid topology settings")
+	}
+	reconnect, err := newBackoff(entry.Reconnect)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"consumerName": entry.Name,
+			"error":        err.Error()}).Fatal("Invalid reconnect settings")
+	}
+	tracker, err := newOffsetTracker(settings.OffsetFile, settings.CommitInterval)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"consumerName": entry.Name,
+			"error":        err.Error()}).Fatal("Could not load stored stream offset")
+	}
+	return StreamConsumer{
+		name:            entry.Name,
+		connectionURLs:  entry.ConnectionURLs,
+		shuffle:         entry.Shuffle,
+		rabbitConnector: rabbitConnector,
+		topology:        streamTopology,
+		transformer:     transformer,
+		filter:          messageFilter,
+		offset:          offset,
+		prefetch:        settings.Prefetch,
+		tracker:         tracker,
+		backoff:         *reconnect,
+		state:           &connectionState{},
+	}
+}
+
+// parseOffset parses offset of stream consumer: first, last, next (default), offset number,
+// RFC3339 timestamp or duration to consume messages of the recent period, e.g. 24h
+func parseOffset(value string, now time.Time) (interface{}, error) {
+	switch value {
+	case "":
+		return NextOffset, nil
+	case FirstOffset, LastOffset, NextOffset:
+		return value, nil
+	}
+	if offset, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if offset < 0 {
+			return nil, fmt.Errorf("stream offset cannot be negative, found %d", offset)
+		}
+		return offset, nil
+	}
+	if timestamp, err := time.Parse(time.RFC3339, value); err == nil {
+		return timestamp, nil
+	}
+	if period, err := time.ParseDuration(value); err == nil && period > 0 {
+		return now.Add(-period), nil
+	}
+	return nil, fmt.Errorf("unknown stream offset %s, expected first, last, next, offset number, RFC3339 timestamp or duration", value)
+}
+
+// Name consumer name
+func (c StreamConsumer) Name() string {
+	return c.name
+}
+
+// Status reports number of filtered out messages, connection state and last forwarded offset
+func (c StreamConsumer) Status() consumer.Status {
+	connection := c.state.get()
+	status := consumer.Status{Delivery: consumer.AtLeastOnce, DroppedMessages: c.filter.Dropped(), Connection: &connection}
+	if offset, ok := c.tracker.get(); ok {
+		status.Offset = &offset
+	}
+	return status
+}
+
+// Start consumes stream from configured offset, after reconnect it continues after the last forwarded message
+func (c StreamConsumer) Start(ctx context.Context, client forwarder.Client, check chan bool, stop chan bool) error {
+	log.WithFields(log.Fields{
+		"exchangeName": c.topology.exchange,
+		"queueName":    c.topology.queue,
+		"offset":       c.offset}).Info("Starting stream consumer")
+	reconnect := c.backoff
+	for {
+		delivery, conn, ch, err := c.initStream()
+		if err != nil {
+			log.Error(err)
+			closeRabbitMQ(conn, ch)
+			c.state.disconnected(err)
+			if retry, err := reconnect.wait(c.Name(), c.state, err, stop); !retry {
+				return err
+			}
+			continue
+		}
+		c.state.connected()
+		closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+		blocked := conn.NotifyBlocked(make(chan amqp.Blocking, 1))
+		params := workerParams{client, delivery, check, stop, conn, ch, closed, blocked, watchCertificates(c.rabbitConnector)}
+		before, beforeTracked := c.tracker.get()
+		err = c.startForwarding(ctx, &params)
+		if err.Error() == closedBySupervisorMessage {
+			break
+		}
+		c.state.disconnected(err)
+		// backoff grows while forwarding does not progress, e.g. when destination is throttling
+		if after, afterTracked := c.tracker.get(); after != before || afterTracked != beforeTracked {
+			reconnect.reset()
+		}
+		if retry, err := reconnect.wait(c.Name(), c.state, err, stop); !retry {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c StreamConsumer) initStream() (<-chan amqp.Delivery, *amqp.Connection, *amqp.Channel, error) {
+	conn, err := connector.Dial(c.rabbitConnector, c.connectionURLs, c.shuffle)
+	if err != nil {
+		return failOnError(err, "Failed to connect to RabbitMQ")
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, conn, nil, fmt.Errorf("Failed to open a channel: %s", err)
+	}
+	if err = ch.Qos(c.prefetch, 0, false); err != nil {
+		return nil, conn, ch, fmt.Errorf("Failed to set prefetch: %s", err)
+	}
+	if err = c.topology.declare(ch); err != nil {
+		return nil, conn, ch, err
+	}
+	offset := c.tracker.next(c.offset)
+	log.WithFields(log.Fields{
+		"consumerName": c.Name(),
+		"offset":       offset}).Info("Consuming stream")
+	msgs, err := ch.Consume(c.topology.queue, c.Name(), false, false, false, false, amqp.Table{streamOffsetHeader: offset})
+	if err != nil {
+		return nil, conn, ch, fmt.Errorf("Failed to register a consumer: %s", err)
+	}
+	return msgs, conn, ch, nil
+}
+
+func (c StreamConsumer) startForwarding(ctx context.Context, params *workerParams) error {
+	forwarderName := params.forwarder.Name()
+	log.WithFields(log.Fields{
+		"consumerName":  c.Name(),
+		"forwarderName": forwarderName}).Info("Started forwarding stream messages")
+	defer c.commit()
+	for {
+		select {
+		case d, ok := <-params.msgs:
+			if !ok {
+				params.closeRabbitMQ()
+				return errors.New(channelClosedMessage)
+			}
+			if err := c.process(ctx, params.forwarder, d); err != nil {
+				if ctx.Err() != nil {
+					return c.close(params, forwarderName)
+				}
+				params.closeRabbitMQ()
+				return err
+			}
+		case amqpErr, ok := <-params.closed:
+			params.closeRabbitMQ()
+			if !ok || amqpErr == nil {
+				return errors.New(connectionClosedMessage)
+			}
+			log.WithFields(log.Fields{
+				"consumerName": c.Name(),
+				"error":        amqpErr.Error()}).Error("RabbitMQ connection closed")
+			return fmt.Errorf("%s: %s", connectionClosedMessage, amqpErr.Error())
+		case <-params.certificates:
+			log.WithField("consumerName", c.Name()).Info("Reconnecting with rotated certificates")
+			params.closeRabbitMQ()
+			return errors.New(certificatesChangedMessage)
+		case b, ok := <-params.blocked:
+			if !ok {
+				params.blocked = nil
+				continue
+			}
+			c.state.blocked(b.Active)
+			if b.Active {
+				log.WithFields(log.Fields{
+					"consumerName": c.Name(),
+					"reason":       b.Reason}).Warn("RabbitMQ connection blocked")
+			} else {
+				log.WithField("consumerName", c.Name()).Info("RabbitMQ connection unblocked")
+			}
+		case <-params.check:
+			log.WithField("forwarderName", forwarderName).Info("Checking")
+		case <-params.stop:
+			return c.close(params, forwarderName)
+		}
+	}
+}
+
+// close cancels consuming when supervisor stops the consumer
+func (c StreamConsumer) close(params *workerParams, forwarderName string) error {
+	log.WithField("forwarderName", forwarderName).Info("Closing")
+	if err := params.ch.Cancel(c.Name(), false); err != nil {
+		log.WithFields(log.Fields{
+			"consumerName": c.Name(),
+			"error":        err.Error()}).Error("Could not cancel consumer")
+	}
+	params.closeRabbitMQ()
+	return errors.New(closedBySupervisorMessage)
+}
+
+// process forwards stream message and acknowledges it, acknowledgement only grants credit for next messages.
+// Transient forwarding failure stops consuming, the stream is consumed again from the failed message after reconnect.
+// Other failures are logged and the message is skipped, streams do not dead-letter messages
+func (c StreamConsumer) process(ctx context.Context, client forwarder.Client, d amqp.Delivery) error {
+	offset, tracked := streamOffset(d)
+	message := newMessage(d)
+	if c.filter.Match(message) {
+		body, err := c.transformer.Apply(message)
+		if err == nil {
+			message.Body = body
+			err = client.Push(ctx, message)
+		}
+		if err != nil && ctx.Err() != nil {
+			// push interrupted by shutdown timeout, consuming continues from the message after restart
+			if tracked {
+				c.tracker.failed(offset)
+			}
+			return fmt.Errorf("forwarding of message at offset %d interrupted: %s", offset, err)
+		}
+		if err != nil && forwarder.IsRetryable(err) {
+			log.WithFields(log.Fields{
+				"consumerName": c.Name(),
+				"offset":       offset,
+				"error":        err.Error()}).Error("Could not forward stream message, consuming it again")
+			if tracked {
+				c.tracker.failed(offset)
+			}
+			return fmt.Errorf("could not forward message at offset %d: %s", offset, err)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"consumerName": c.Name(),
+				"offset":       offset,
+				"error":        err.Error()}).Error("Could not forward stream message, skipping it")
+		}
+	}
+	if err := d.Ack(false); err != nil {
+		return fmt.Errorf("could not ack message at offset %d: %s", offset, err)
+	}
+	if tracked {
+		if err := c.tracker.forwarded(offset); err != nil {
+			log.WithFields(log.Fields{
+				"consumerName": c.Name(),
+				"error":        err.Error()}).Error("Could not store stream offset")
+		}
+	}
+	return nil
+}
+
+func (c StreamConsumer) commit() {
+	if err := c.tracker.commit(); err != nil {
+		log.WithFields(log.Fields{
+			"consumerName": c.Name(),
+			"error":        err.Error()}).Error("Could not store stream offset")
+	}
+}
+
+// streamOffset reads offset of the message in the stream
+func streamOffset(d amqp.Delivery) (int64, bool) {
+	switch offset := d.Headers[streamOffsetHeader].(type) {
+	case int64:
+		return offset, true
+	case int32:
+		return int64(offset), true
+	case int:
+		return int64(offset), true
+	}
+	return 0, false
+}
+
+// offsetTracker last forwarded offset shared by connections of the consumer,
+// it is written to offset file every commit interval and when consuming stops
+type offsetTracker struct {
+	sync.Mutex
+	file           string
+	commitInterval int
+	offset         int64
+	tracked        bool
+	uncommitted    int
+	// resume offset of message which failed transiently, consuming continues from it after reconnect
+	resume   int64
+	resuming bool
+}
+
+// newOffsetTracker creates tracker with offset stored in the file, missing file means no stored offset
+func newOffsetTracker(file string, commitInterval int) (*offsetTracker, error) {
+	t := &offsetTracker{file: file, commitInterval: commitInterval}
+	if file == "" {
+		return t, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	offset, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stream offset in %s: %s", file, err)
+	}
+	log.WithFields(log.Fields{
+		"offsetFile": file,
+		"offset":     offset}).Info("Loaded stored stream offset")
+	t.offset, t.tracked = offset, true
+	return t, nil
+}
+
+// next offset to consume from: the failed message, the one after last forwarded message
+// or the configured one until any message is forwarded
+func (t *offsetTracker) next(configured interface{}) interface{} {
+	t.Lock()
+	defer t.Unlock()
+	if t.resuming {
+		return t.resume
+	}
+	if t.tracked {
+		return t.offset + 1
+	}
+	return configured
+}
+
+func (t *offsetTracker) get() (int64, bool) {
+	t.Lock()
+	defer t.Unlock()
+	return t.offset, t.tracked
+}
+
+// forwarded moves tracked offset, it is written to offset file every commit interval
+func (t *offsetTracker) forwarded(offset int64) error {
+	t.Lock()
+	defer t.Unlock()
+	t.offset, t.tracked = offset, true
+	t.resuming = false
+	t.uncommitted++
+	if t.uncommitted < t.commitInterval {
+		return nil
+	}
+	return t.write()
+}
+
+// failed marks offset of the message which has to be consumed again, the tracked offset stays
+// unchanged, so offsets of failed messages are never written to offset file
+func (t *offsetTracker) failed(offset int64) {
+	t.Lock()
+	defer t.Unlock()
+	t.resume, t.resuming = offset, true
+}
+
+// commit writes tracked offset to offset file
+func (t *offsetTracker) commit() error {
+	t.Lock()
+	defer t.Unlock()
+	return t.write()
+}
+
+// write replaces offset file, so it is never left partially written
+func (t *offsetTracker) write() error {
+	if t.file == "" || !t.tracked || t.uncommitted == 0 {
+		t.uncommitted = 0
+		return nil
+	}
+	tmp := t.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(strconv.FormatInt(t.offset, 10)), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, t.file); err != nil {
+		return err
+	}
+	t.uncommitted = 0
+	return nil
+}
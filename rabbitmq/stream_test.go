@@ -0,0 +1,192 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
+	"github.com/AirHelp/rabbit-amazon-forwarder/filter"
+	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
+)
+
+func TestParseOffset(t *testing.T) {
+	now := time.Date(2020, 1, 2, 12, 0, 0, 0, time.UTC)
+	scenarios := []struct {
+		name     string
+		value    string
+		expected interface{}
+		valid    bool
+	}{
+		{name: "default", value: "", expected: NextOffset, valid: true},
+		{name: "first", value: "first", expected: FirstOffset, valid: true},
+		{name: "last", value: "last", expected: LastOffset, valid: true},
+		{name: "offset number", value: "1500", expected: int64(1500), valid: true},
+		{name: "timestamp", value: "2020-01-01T00:00:00Z", expected: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), valid: true},
+		{name: "recent period", value: "24h", expected: now.Add(-24 * time.Hour), valid: true},
+		{name: "negative offset", value: "-1", valid: false},
+		{name: "unknown offset", value: "beginning", valid: false},
+	}
+	for _, scenario := range scenarios {
+		t.Log("Scenario name: ", scenario.name)
+		offset, err := parseOffset(scenario.value, now)
+		if !scenario.valid {
+			if err == nil {
+				t.Errorf("offset should not be parsed")
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("offset should be parsed, got error: %s", err.Error())
+			continue
+		}
+		if timestamp, ok := scenario.expected.(time.Time); ok {
+			if parsed, ok := offset.(time.Time); !ok || !parsed.Equal(timestamp) {
+				t.Errorf("wrong offset, expected: %v, got: %v", scenario.expected, offset)
+			}
+			continue
+		}
+		if offset != scenario.expected {
+			t.Errorf("wrong offset, expected: %v, got: %v", scenario.expected, offset)
+		}
+	}
+}
+
+func TestOffsetTracker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "offsets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "offset")
+
+	tracker, err := newOffsetTracker(file, 2)
+	if err != nil {
+		t.Fatalf("tracker without stored offset should be created, got error: %s", err.Error())
+	}
+	if next := tracker.next(FirstOffset); next != FirstOffset {
+		t.Errorf("configured offset should be used before any message is forwarded, got: %v", next)
+	}
+	tracker.forwarded(10)
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("offset should not be stored before commit interval")
+	}
+	if next := tracker.next(FirstOffset); next != int64(11) {
+		t.Errorf("consuming should continue after forwarded offset, got: %v", next)
+	}
+	tracker.forwarded(11)
+	if data, _ := ioutil.ReadFile(file); string(data) != "11" {
+		t.Errorf("offset should be stored after commit interval, got: %q", data)
+	}
+	tracker.forwarded(12)
+	if err := tracker.commit(); err != nil {
+		t.Errorf("offset should be committed, got error: %s", err.Error())
+	}
+
+	restored, err := newOffsetTracker(file, 2)
+	if err != nil {
+		t.Fatalf("tracker with stored offset should be created, got error: %s", err.Error())
+	}
+	if next := restored.next(FirstOffset); next != int64(13) {
+		t.Errorf("consuming should continue after stored offset, got: %v", next)
+	}
+
+	ioutil.WriteFile(file, []byte("not a number"), 0644)
+	if _, err := newOffsetTracker(file, 2); err == nil {
+		t.Errorf("invalid stored offset should be reported")
+	}
+}
+
+func TestStreamProcess(t *testing.T) {
+	equals := "orders"
+	messageFilter, _ := filter.New([]config.FilterEntry{{Field: "routingKey", Equals: &equals}})
+	scenarios := []struct {
+		name       string
+		routingKey string
+		err        error
+		untracked  bool
+		failed     bool
+		pushed     int
+		acked      bool
+		offset     int64
+		next       interface{}
+	}{
+		{name: "forwarded", routingKey: "orders", pushed: 1, acked: true, offset: 7, next: int64(8)},
+		{name: "filtered out", routingKey: "payments", pushed: 0, acked: true, offset: 7, next: int64(8)},
+		{name: "permanent failure", routingKey: "orders", err: errors.New("invalid message"), pushed: 1, acked: true, offset: 7, next: int64(8)},
+		{name: "transient failure", routingKey: "orders", err: forwarder.RetryableError{Err: errors.New("throttled")}, failed: true, pushed: 1, acked: false, offset: 6, next: int64(7)},
+		{name: "transient failure of first message", routingKey: "orders", err: forwarder.RetryableError{Err: errors.New("throttled")}, untracked: true, failed: true, pushed: 1, acked: false, next: int64(7)},
+	}
+	for _, scenario := range scenarios {
+		t.Log("Scenario name: ", scenario.name)
+		tracker, _ := newOffsetTracker("", DefaultCommitInterval)
+		if !scenario.untracked {
+			tracker.forwarded(6)
+		}
+		c := StreamConsumer{name: "stream", filter: messageFilter, tracker: tracker, state: &connectionState{}}
+		client := &mockStreamForwarder{err: scenario.err}
+		acknowledger := &mockAcknowledger{}
+		d := amqp.Delivery{Acknowledger: acknowledger, RoutingKey: scenario.routingKey, Body: []byte("{}"), Headers: amqp.Table{streamOffsetHeader: int64(7)}}
+
+		err := c.process(context.Background(), client, d)
+
+		if scenario.failed != (err != nil) {
+			t.Errorf("wrong result, expected failure: %t, got error: %v", scenario.failed, err)
+		}
+		if len(client.pushed) != scenario.pushed {
+			t.Errorf("wrong number of pushed messages, expected: %d, got: %d", scenario.pushed, len(client.pushed))
+		}
+		if scenario.acked != (acknowledger.result == "ack") {
+			t.Errorf("wrong acknowledgement, expected ack: %t, got: %q", scenario.acked, acknowledger.result)
+		}
+		if offset := c.Status().Offset; scenario.untracked && offset != nil {
+			t.Errorf("failed message should not be tracked, got offset: %d", *offset)
+		} else if !scenario.untracked && *offset != scenario.offset {
+			t.Errorf("wrong tracked offset, expected: %d, got: %d", scenario.offset, *offset)
+		}
+		if next := tracker.next(NextOffset); next != scenario.next {
+			t.Errorf("wrong offset to consume from after reconnect, expected: %v, got: %v", scenario.next, next)
+		}
+	}
+}
+
+func TestStreamProcessInterrupted(t *testing.T) {
+	tracker, _ := newOffsetTracker("", DefaultCommitInterval)
+	tracker.forwarded(6)
+	c := StreamConsumer{name: "stream", tracker: tracker, state: &connectionState{}}
+	client := &mockStreamForwarder{err: errors.New("request canceled")}
+	acknowledger := &mockAcknowledger{}
+	d := amqp.Delivery{Acknowledger: acknowledger, Body: []byte("{}"), Headers: amqp.Table{streamOffsetHeader: int64(7)}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := c.process(ctx, client, d); err == nil {
+		t.Errorf("interrupted push should stop consuming")
+	}
+	if acknowledger.result != "" {
+		t.Errorf("interrupted message should not be acknowledged, got: %q", acknowledger.result)
+	}
+	if next := tracker.next(NextOffset); next != int64(7) {
+		t.Errorf("consuming should continue from interrupted message, got: %v", next)
+	}
+}
+
+type mockStreamForwarder struct {
+	err    error
+	pushed []string
+}
+
+func (f *mockStreamForwarder) Name() string {
+	return "forwarder"
+}
+
+func (f *mockStreamForwarder) Push(ctx context.Context, message forwarder.Message) error {
+	f.pushed = append(f.pushed, message.Body)
+	return f.err
+}
@@ -0,0 +1,297 @@
+package rabbitmq
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
+)
+
+const (
+	// DefaultExchangeType type of declared exchange
+	DefaultExchangeType = amqp.ExchangeTopic
+	// ClassicQueue classic queue type
+	ClassicQueue = "classic"
+	// QuorumQueue replicated quorum queue type
+	QuorumQueue = "quorum"
+	// StreamQueue append-only stream, consumed by stream consumer
+	StreamQueue = "stream"
+	// DefaultRequeueDelay wait before the first requeue of transiently failed message
+	DefaultRequeueDelay = time.Second
+	// MaxRequeueDelay limit of requeue delay, which doubles with every delivery of the message
+	MaxRequeueDelay = time.Minute
+)
+
+const (
+	deadLetterSuffix      = "-dead-letter"
+	deadLetterExchangeArg = "x-dead-letter-exchange"
+)
+
+// topologyChannel channel operations used to declare topology, implemented by amqp.Channel
+type topologyChannel interface {
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	ExchangeDeclarePassive(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueDeclarePassive(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+}
+
+// binding queue binding with optional headers exchange arguments
+type binding struct {
+	routingKey string
+	args       amqp.Table
+}
+
+// topology exchange, queue and its dead-letter queue of the consumer
+type topology struct {
+	passive      bool
+	exchange     string
+	exchangeType string
+	queue        string
+	queueType    string
+	queueArgs    amqp.Table
+	bindings     []binding
+	// deliveryLimit number of deliveries after which quorum queue dead-letters the message
+	deliveryLimit int
+	// requeueDelay wait before transiently failed message is requeued
+	requeueDelay time.Duration
+	// deadLetter dead-letter exchange and queue are declared, unless queue arguments set own exchange
+	deadLetter bool
+}
+
+// newTopology creates topology from mapping entry, default one is a durable topic exchange
+// and classic durable queue with fanout dead-letter exchange
+func newTopology(entry config.RabbitEntry) (*topology, error) {
+	t := &topology{
+		exchange:     entry.ExchangeName,
+		exchangeType: DefaultExchangeType,
+		queue:        entry.QueueName,
+		queueType:    ClassicQueue,
+		queueArgs:    amqp.Table{},
+		deadLetter:   true,
+	}
+	settings := entry.Topology
+	if settings == nil {
+		settings = &config.TopologyEntry{}
+	}
+	t.passive = settings.Passive
+	if settings.ExchangeType != "" {
+		t.exchangeType = settings.ExchangeType
+	}
+	switch t.exchangeType {
+	case amqp.ExchangeTopic, amqp.ExchangeDirect, amqp.ExchangeFanout, amqp.ExchangeHeaders:
+	default:
+		return nil, fmt.Errorf("unknown exchange type %s, expected one of topic, direct, fanout, headers", t.exchangeType)
+	}
+	if settings.QueueType != "" {
+		t.queueType = settings.QueueType
+	}
+	switch t.queueType {
+	case ClassicQueue:
+	case QuorumQueue:
+		if settings.Lazy {
+			return nil, fmt.Errorf("lazy mode is supported only by %s queues", ClassicQueue)
+		}
+		t.queueArgs["x-queue-type"] = QuorumQueue
+	case StreamQueue:
+		if settings.Lazy {
+			return nil, fmt.Errorf("lazy mode is supported only by %s queues", ClassicQueue)
+		}
+		// streams do not dead-letter messages, they are kept until retention limits
+		t.deadLetter = false
+		t.queueArgs["x-queue-type"] = StreamQueue
+	default:
+		return nil, fmt.Errorf("unknown queue type %s, expected one of %s, %s, %s", t.queueType, ClassicQueue, QuorumQueue, StreamQueue)
+	}
+	if settings.DeliveryLimit < 0 {
+		return nil, fmt.Errorf("delivery limit cannot be negative, found %d", settings.DeliveryLimit)
+	}
+	if settings.DeliveryLimit > 0 {
+		if t.queueType != QuorumQueue {
+			return nil, fmt.Errorf("delivery limit is supported only by %s queues", QuorumQueue)
+		}
+		t.deliveryLimit = settings.DeliveryLimit
+		t.queueArgs["x-delivery-limit"] = settings.DeliveryLimit
+		t.requeueDelay = DefaultRequeueDelay
+	}
+	if settings.RequeueDelay != "" {
+		if t.deliveryLimit == 0 {
+			return nil, fmt.Errorf("requeue delay requires delivery limit")
+		}
+		delay, err := time.ParseDuration(settings.RequeueDelay)
+		if err != nil {
+			return nil, err
+		}
+		if delay < 0 || delay > MaxRequeueDelay {
+			return nil, fmt.Errorf("requeue delay has to be between 0 and %s, found %s", MaxRequeueDelay, settings.RequeueDelay)
+		}
+		t.requeueDelay = delay
+	}
+	if settings.MaxLength < 0 {
+		return nil, fmt.Errorf("queue max length cannot be negative, found %d", settings.MaxLength)
+	}
+	if settings.MaxLength > 0 {
+		t.queueArgs["x-max-length"] = settings.MaxLength
+	}
+	if settings.MessageTTL != "" {
+		ttl, err := time.ParseDuration(settings.MessageTTL)
+		if err != nil {
+			return nil, err
+		}
+		if ttl <= 0 {
+			return nil, fmt.Errorf("message TTL has to be positive, found %s", settings.MessageTTL)
+		}
+		t.queueArgs["x-message-ttl"] = ttl.Milliseconds()
+	}
+	if settings.Lazy {
+		t.queueArgs["x-queue-mode"] = "lazy"
+	}
+	if settings.SingleActiveConsumer {
+		t.queueArgs["x-single-active-consumer"] = true
+	}
+	for key, value := range settings.Arguments {
+		t.queueArgs[key] = argument(value)
+	}
+	if _, ok := t.queueArgs[deadLetterExchangeArg]; ok {
+		t.deadLetter = false
+	} else if t.deadLetter {
+		t.queueArgs[deadLetterExchangeArg] = t.deadLetterName()
+	}
+	for _, routingKey := range entry.RoutingKeys {
+		t.bindings = append(t.bindings, binding{routingKey: routingKey})
+	}
+	for _, b := range settings.Bindings {
+		headersBinding, err := newBinding(b)
+		if err != nil {
+			return nil, err
+		}
+		t.bindings = append(t.bindings, headersBinding)
+	}
+	if t.exchangeType == amqp.ExchangeHeaders && !t.passive {
+		for _, b := range t.bindings {
+			if len(b.args) == 0 {
+				return nil, fmt.Errorf("bindings of headers exchange %s need headers", t.exchange)
+			}
+		}
+	}
+	if t.exchangeType == amqp.ExchangeFanout && len(t.bindings) == 0 {
+		t.bindings = append(t.bindings, binding{})
+	}
+	return t, nil
+}
+
+func newBinding(entry config.BindingEntry) (binding, error) {
+	b := binding{routingKey: entry.RoutingKey}
+	if len(entry.Headers) == 0 {
+		if entry.Match != "" {
+			return b, fmt.Errorf("binding match %s requires headers", entry.Match)
+		}
+		return b, nil
+	}
+	match := entry.Match
+	if match == "" {
+		match = "all"
+	}
+	if match != "all" && match != "any" {
+		return b, fmt.Errorf("unknown binding match %s, expected all or any", entry.Match)
+	}
+	b.args = amqp.Table{"x-match": match}
+	for key, value := range entry.Headers {
+		b.args[key] = argument(value)
+	}
+	return b, nil
+}
+
+// argument converts whole JSON numbers to integers, the broker rejects floating point
+// values of arguments like x-max-length
+func argument(value interface{}) interface{} {
+	if number, ok := value.(float64); ok && number == math.Trunc(number) && math.Abs(number) < math.MaxInt64 {
+		return int64(number)
+	}
+	return value
+}
+
+// requeue checks if transient failures are requeued, the broker dead-letters messages after delivery limit
+func (t *topology) requeue() bool {
+	return t != nil && t.deliveryLimit > 0
+}
+
+// requeueWait delay before requeue of message delivered count times before, it doubles
+// with every delivery up to MaxRequeueDelay
+func (t *topology) requeueWait(count int64) time.Duration {
+	wait := t.requeueDelay
+	for i := int64(0); i < count && wait < MaxRequeueDelay; i++ {
+		wait *= 2
+	}
+	if wait > MaxRequeueDelay {
+		return MaxRequeueDelay
+	}
+	return wait
+}
+
+func (t *topology) deadLetterName() string {
+	return t.queue + deadLetterSuffix
+}
+
+// declare declares exchange, queue and bindings. In passive mode it fails when exchange or queue does not exist
+func (t *topology) declare(ch topologyChannel) error {
+	if t.passive {
+		return t.check(ch)
+	}
+	if err := ch.ExchangeDeclare(t.exchange, t.exchangeType, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("Failed to declare an exchange:%s: %s", t.exchange, err)
+	}
+	if t.deadLetter {
+		if err := t.declareDeadLetter(ch); err != nil {
+			return err
+		}
+	}
+	if _, err := ch.QueueDeclare(t.queue, true, false, false, false, t.queueArgs); err != nil {
+		return fmt.Errorf("Failed to declare a queue:%s: %s", t.queue, err)
+	}
+	for _, b := range t.bindings {
+		if err := ch.QueueBind(t.queue, b.routingKey, t.exchange, false, b.args); err != nil {
+			return fmt.Errorf("Failed to bind a queue:%s: %s", t.queue, err)
+		}
+	}
+	return nil
+}
+
+// declareDeadLetter declares fanout dead-letter exchange with queue of the same type as consumed queue
+func (t *topology) declareDeadLetter(ch topologyChannel) error {
+	name := t.deadLetterName()
+	if err := ch.ExchangeDeclare(name, amqp.ExchangeFanout, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("Failed to declare an exchange:%s: %s", name, err)
+	}
+	var args amqp.Table
+	if t.queueType != ClassicQueue {
+		args = amqp.Table{"x-queue-type": t.queueType}
+	}
+	if _, err := ch.QueueDeclare(name, true, false, false, false, args); err != nil {
+		return fmt.Errorf("Failed to declare a queue:%s: %s", name, err)
+	}
+	if err := ch.QueueBind(name, "#", name, false, nil); err != nil {
+		return fmt.Errorf("Failed to bind a queue:%s: %s", name, err)
+	}
+	return nil
+}
+
+// check asserts that exchange and queue exist
+func (t *topology) check(ch topologyChannel) error {
+	log.WithFields(log.Fields{
+		"exchangeName": t.exchange,
+		"queueName":    t.queue}).Info("Checking existing exchange and queue")
+	if t.exchange != "" {
+		if err := ch.ExchangeDeclarePassive(t.exchange, t.exchangeType, true, false, false, false, nil); err != nil {
+			return fmt.Errorf("Exchange does not exist:%s: %s", t.exchange, err)
+		}
+	}
+	if _, err := ch.QueueDeclarePassive(t.queue, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("Queue does not exist:%s: %s", t.queue, err)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package rabbitmq
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
+)
+
+func TestNewTopology(t *testing.T) {
+	scenarios := []struct {
+		name     string
+		topology *config.TopologyEntry
+		routing  []string
+		valid    bool
+	}{
+		{name: "defaults", topology: nil, routing: []string{"#"}, valid: true},
+		{name: "quorum queue", topology: &config.TopologyEntry{QueueType: "quorum", MaxLength: 1000, MessageTTL: "1h"}, valid: true},
+		{name: "lazy quorum queue", topology: &config.TopologyEntry{QueueType: "quorum", Lazy: true}, valid: false},
+		{name: "unknown queue type", topology: &config.TopologyEntry{QueueType: "priority"}, valid: false},
+		{name: "stream", topology: &config.TopologyEntry{QueueType: "stream", Arguments: map[string]interface{}{"x-max-age": "7D"}}, valid: true},
+		{name: "lazy stream", topology: &config.TopologyEntry{QueueType: "stream", Lazy: true}, valid: false},
+		{name: "unknown exchange type", topology: &config.TopologyEntry{ExchangeType: "x-consistent-hash"}, valid: false},
+		{name: "invalid message ttl", topology: &config.TopologyEntry{MessageTTL: "hour"}, valid: false},
+		{name: "negative max length", topology: &config.TopologyEntry{MaxLength: -1}, valid: false},
+		{name: "headers exchange with routing keys", topology: &config.TopologyEntry{ExchangeType: "headers"}, routing: []string{"#"}, valid: false},
+		{name: "passive headers exchange", topology: &config.TopologyEntry{ExchangeType: "headers", Passive: true}, routing: []string{"#"}, valid: true},
+		{name: "quorum queue with delivery limit", topology: &config.TopologyEntry{QueueType: "quorum", DeliveryLimit: 5}, valid: true},
+		{name: "classic queue with delivery limit", topology: &config.TopologyEntry{DeliveryLimit: 5}, valid: false},
+		{name: "negative delivery limit", topology: &config.TopologyEntry{QueueType: "quorum", DeliveryLimit: -1}, valid: false},
+		{name: "requeue delay", topology: &config.TopologyEntry{QueueType: "quorum", DeliveryLimit: 5, RequeueDelay: "5s"}, valid: true},
+		{name: "requeue delay without delivery limit", topology: &config.TopologyEntry{QueueType: "quorum", RequeueDelay: "5s"}, valid: false},
+		{name: "invalid requeue delay", topology: &config.TopologyEntry{QueueType: "quorum", DeliveryLimit: 5, RequeueDelay: "soon"}, valid: false},
+		{name: "too long requeue delay", topology: &config.TopologyEntry{QueueType: "quorum", DeliveryLimit: 5, RequeueDelay: "1h"}, valid: false},
+		{name: "unknown binding match", topology: &config.TopologyEntry{ExchangeType: "headers", Bindings: []config.BindingEntry{{Headers: map[string]interface{}{"type": "order"}, Match: "some"}}}, valid: false},
+	}
+	for _, scenario := range scenarios {
+		t.Log("Scenario name: ", scenario.name)
+		_, err := newTopology(config.RabbitEntry{ExchangeName: "exchange", QueueName: "queue", RoutingKeys: scenario.routing, Topology: scenario.topology})
+		if scenario.valid && err != nil {
+			t.Errorf("topology should be created, got error: %s", err.Error())
+		}
+		if !scenario.valid && err == nil {
+			t.Errorf("topology should not be created")
+		}
+	}
+}
+
+func TestRequeueWait(t *testing.T) {
+	defaults, _ := newTopology(config.RabbitEntry{QueueName: "queue", Topology: &config.TopologyEntry{QueueType: "quorum", DeliveryLimit: 5}})
+	immediate, _ := newTopology(config.RabbitEntry{QueueName: "queue", Topology: &config.TopologyEntry{QueueType: "quorum", DeliveryLimit: 5, RequeueDelay: "0s"}})
+	scenarios := []struct {
+		name     string
+		topology *topology
+		count    int64
+		expected time.Duration
+	}{
+		{name: "first delivery", topology: defaults, count: 0, expected: time.Second},
+		{name: "third delivery", topology: defaults, count: 2, expected: 4 * time.Second},
+		{name: "many deliveries", topology: defaults, count: 100, expected: MaxRequeueDelay},
+		{name: "disabled delay", topology: immediate, count: 3, expected: 0},
+	}
+	for _, scenario := range scenarios {
+		t.Log("Scenario name: ", scenario.name)
+		if wait := scenario.topology.requeueWait(scenario.count); wait != scenario.expected {
+			t.Errorf("wrong requeue delay, expected %s, got %s", scenario.expected, wait)
+		}
+	}
+}
+
+func TestDeclareDefaultTopology(t *testing.T) {
+	queueTopology, _ := newTopology(config.RabbitEntry{ExchangeName: "exchange", QueueName: "queue", RoutingKeys: []string{"a.#", "b.*"}})
+	ch := &mockTopologyChannel{}
+
+	if err := queueTopology.declare(ch); err != nil {
+		t.Fatalf("topology should be declared, got error: %s", err.Error())
+	}
+
+	expected := []string{
+		"exchange exchange topic",
+		"exchange queue-dead-letter fanout",
+		"queue queue-dead-letter map[]",
+		"bind queue-dead-letter # queue-dead-letter map[]",
+		"queue queue map[x-dead-letter-exchange:queue-dead-letter]",
+		"bind queue a.# exchange map[]",
+		"bind queue b.* exchange map[]",
+	}
+	if !reflect.DeepEqual(ch.calls, expected) {
+		t.Errorf("wrong declarations, expected %v, got %v", expected, ch.calls)
+	}
+}
+
+func TestDeclareQuorumTopology(t *testing.T) {
+	var entry config.TopologyEntry
+	mapping := `{
+		"exchangeType": "headers",
+		"queueType": "quorum",
+		"maxLength": 1000,
+		"messageTTL": "1m",
+		"singleActiveConsumer": true,
+		"arguments": {"x-max-length-bytes": 1048576},
+		"bindings": [{"headers": {"type": "order", "version": 2}, "match": "any"}]
+	}`
+	if err := json.Unmarshal([]byte(mapping), &entry); err != nil {
+		t.Fatal(err)
+	}
+	queueTopology, err := newTopology(config.RabbitEntry{ExchangeName: "exchange", QueueName: "queue", Topology: &entry})
+	if err != nil {
+		t.Fatalf("topology should be created, got error: %s", err.Error())
+	}
+	ch := &mockTopologyChannel{}
+
+	if err := queueTopology.declare(ch); err != nil {
+		t.Fatalf("topology should be declared, got error: %s", err.Error())
+	}
+
+	expected := []string{
+		"exchange exchange headers",
+		"exchange queue-dead-letter fanout",
+		"queue queue-dead-letter map[x-queue-type:quorum]",
+		"bind queue-dead-letter # queue-dead-letter map[]",
+		"queue queue map[x-dead-letter-exchange:queue-dead-letter x-max-length:1000 x-max-length-bytes:1048576 x-message-ttl:60000 x-queue-type:quorum x-single-active-consumer:true]",
+		"bind queue  exchange map[type:order version:2 x-match:any]",
+	}
+	if !reflect.DeepEqual(ch.calls, expected) {
+		t.Errorf("wrong declarations, expected %v, got %v", expected, ch.calls)
+	}
+	if _, ok := ch.queueArgs["x-max-length-bytes"].(int64); !ok {
+		t.Errorf("whole number argument should be an integer, got %T", ch.queueArgs["x-max-length-bytes"])
+	}
+}
+
+func TestDeclareFanoutTopologyWithOwnDeadLetterExchange(t *testing.T) {
+	entry := &config.TopologyEntry{ExchangeType: "fanout", Arguments: map[string]interface{}{"x-dead-letter-exchange": "platform-dlx"}}
+	queueTopology, _ := newTopology(config.RabbitEntry{ExchangeName: "exchange", QueueName: "queue", Topology: entry})
+	ch := &mockTopologyChannel{}
+
+	if err := queueTopology.declare(ch); err != nil {
+		t.Fatalf("topology should be declared, got error: %s", err.Error())
+	}
+
+	expected := []string{
+		"exchange exchange fanout",
+		"queue queue map[x-dead-letter-exchange:platform-dlx]",
+		"bind queue  exchange map[]",
+	}
+	if !reflect.DeepEqual(ch.calls, expected) {
+		t.Errorf("wrong declarations, expected %v, got %v", expected, ch.calls)
+	}
+}
+
+func TestDeclarePassiveTopology(t *testing.T) {
+	queueTopology, _ := newTopology(config.RabbitEntry{ExchangeName: "exchange", QueueName: "queue", RoutingKeys: []string{"#"}, Topology: &config.TopologyEntry{Passive: true}})
+	ch := &mockTopologyChannel{}
+
+	if err := queueTopology.declare(ch); err != nil {
+		t.Fatalf("topology should be checked, got error: %s", err.Error())
+	}
+	expected := []string{"check exchange exchange", "check queue queue"}
+	if !reflect.DeepEqual(ch.calls, expected) {
+		t.Errorf("wrong declarations, expected %v, got %v", expected, ch.calls)
+	}
+
+	ch = &mockTopologyChannel{missing: "queue"}
+	if err := queueTopology.declare(ch); err == nil {
+		t.Errorf("missing queue should be reported")
+	}
+}
+
+type mockTopologyChannel struct {
+	calls     []string
+	queueArgs amqp.Table
+	missing   string
+}
+
+func (c *mockTopologyChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	c.calls = append(c.calls, "exchange "+name+" "+kind)
+	return nil
+}
+
+func (c *mockTopologyChannel) ExchangeDeclarePassive(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	c.calls = append(c.calls, "check exchange "+name)
+	if name == c.missing {
+		return errors.New("NOT_FOUND")
+	}
+	return nil
+}
+
+func (c *mockTopologyChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	c.calls = append(c.calls, "queue "+name+" "+tableString(args))
+	if args != nil {
+		c.queueArgs = args
+	}
+	return amqp.Queue{Name: name}, nil
+}
+
+func (c *mockTopologyChannel) QueueDeclarePassive(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	c.calls = append(c.calls, "check queue "+name)
+	if name == c.missing {
+		return amqp.Queue{}, errors.New("NOT_FOUND")
+	}
+	return amqp.Queue{Name: name}, nil
+}
+
+func (c *mockTopologyChannel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	c.calls = append(c.calls, "bind "+name+" "+key+" "+exchange+" "+tableString(args))
+	return nil
+}
+
+func tableString(table amqp.Table) string {
+	return fmt.Sprint(map[string]interface{}(table))
+}
@@ -0,0 +1,235 @@
+package s3
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
+	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Type forwarder type
+	Type = "S3"
+	// DefaultKey object key template
+	DefaultKey = "{{.Name}}/{{.Year}}/{{.Month}}/{{.Day}}/{{.Hour}}/{{.ID}}"
+	// MaxBatchSize maximum number of messages in one object
+	MaxBatchSize = 10000
+	// DefaultBatchInterval time after which incomplete batch is written
+	DefaultBatchInterval = time.Minute
+	jsonExtension        = ".json"
+	jsonLinesExtension   = ".jsonl"
+	gzipExtension        = ".gz"
+	gzipEncoding         = "gzip"
+	recordDelimiter      = "\n"
+)
+
+// Forwarder forwarding client writing every message as separate object
+type Forwarder struct {
+	name      string
+	s3Client  s3iface.S3API
+	bucket    string
+	key       *template.Template
+	extension string
+	gzip      bool
+	timeout   time.Duration
+}
+
+// BatchForwarder forwarding client writing batches of messages as JSON lines objects
+type BatchForwarder struct {
+	Forwarder
+	batchSize     int
+	batchInterval time.Duration
+}
+
+// keyData values available in object key template
+type keyData struct {
+	Name       string
+	RoutingKey string
+	Exchange   string
+	MessageID  string
+	ID         string
+	Year       string
+	Month      string
+	Day        string
+	Hour       string
+	Minute     string
+}
+
+// CreateForwarder creates instance of forwarder, batch forwarder if batching is configured
+func CreateForwarder(entry config.AmazonEntry, s3Client ...s3iface.S3API) forwarder.Client {
+	var client s3iface.S3API
+	if len(s3Client) > 0 {
+		client = s3Client[0]
+	} else {
+		client = s3.New(session.Must(session.NewSession()))
+	}
+	keyTemplate, extension := entry.Key, ""
+	if keyTemplate == "" {
+		keyTemplate, extension = DefaultKey, jsonExtension
+		if entry.Batch != nil {
+			extension = jsonLinesExtension
+		}
+		if entry.Gzip {
+			extension += gzipExtension
+		}
+	}
+	key, err := template.New("key").Parse(keyTemplate)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"forwarderName": entry.Name,
+			"error":         err.Error()}).Fatal("Could not parse object key template")
+	}
+	batchSize, batchInterval, err := forwarder.BatchSettings(entry.Batch, MaxBatchSize, DefaultBatchInterval)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"forwarderName": entry.Name,
+			"error":         err.Error()}).Fatal("Could not parse batch interval")
+	}
+	timeout, err := forwarder.Timeout(entry.Timeout, forwarder.DefaultTimeout)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"forwarderName": entry.Name,
+			"error":         err.Error()}).Fatal("Invalid push timeout")
+	}
+	forwarder := Forwarder{entry.Name, client, entry.Target, key, extension, entry.Gzip, timeout}
+	log.WithField("forwarderName", forwarder.Name()).Info("Created forwarder")
+	if entry.Batch == nil {
+		return forwarder
+	}
+	return BatchForwarder{forwarder, batchSize, batchInterval}
+}
+
+// Name forwarder name
+func (f Forwarder) Name() string {
+	return f.name
+}
+
+// Push writes message as S3 object, push is cancelled when context is done or timeout passes
+func (f Forwarder) Push(ctx context.Context, message forwarder.Message) error {
+	if message.Body == "" {
+		return errors.New(forwarder.EmptyMessageError)
+	}
+	return f.putObject(ctx, message, []byte(message.Body))
+}
+
+// BatchSize maximum number of messages in a batch
+func (f BatchForwarder) BatchSize() int {
+	return f.batchSize
+}
+
+// BatchInterval time after which incomplete batch is forwarded
+func (f BatchForwarder) BatchInterval() time.Duration {
+	return f.batchInterval
+}
+
+// PushBatch writes messages as one JSON lines object, object key is based on the first message
+func (f BatchForwarder) PushBatch(ctx context.Context, messages []forwarder.Message) []error {
+	errs := make([]error, len(messages))
+	var buf bytes.Buffer
+	var indexes []int
+	for i, message := range messages {
+		if message.Body == "" {
+			errs[i] = errors.New(forwarder.EmptyMessageError)
+			continue
+		}
+		buf.WriteString(message.Body)
+		if !strings.HasSuffix(message.Body, recordDelimiter) {
+			buf.WriteString(recordDelimiter)
+		}
+		indexes = append(indexes, i)
+	}
+	if len(indexes) == 0 {
+		return errs
+	}
+	if err := f.putObject(ctx, messages[indexes[0]], buf.Bytes()); err != nil {
+		for _, i := range indexes {
+			errs[i] = err
+		}
+	}
+	return errs
+}
+
+func (f Forwarder) putObject(ctx context.Context, message forwarder.Message, body []byte) error {
+	key, err := f.objectKey(message)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"forwarderName": f.Name(),
+			"error":         err.Error()}).Error("Could not create object key")
+		return err
+	}
+	params := &s3.PutObjectInput{
+		Bucket: aws.String(f.bucket),
+		Key:    aws.String(key),
+	}
+	if f.gzip {
+		if body, err = compress(body); err != nil {
+			return err
+		}
+		params.ContentEncoding = aws.String(gzipEncoding)
+	}
+	params.Body = bytes.NewReader(body)
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+	if _, err = f.s3Client.PutObjectWithContext(ctx, params); err != nil {
+		log.WithFields(log.Fields{
+			"forwarderName": f.Name(),
+			"error":         err.Error()}).Error("Could not forward message")
+		return err
+	}
+	log.WithFields(log.Fields{
+		"forwarderName": f.Name(),
+		"key":           key}).Info("Forward succeeded")
+	return nil
+}
+
+func (f Forwarder) objectKey(message forwarder.Message) (string, error) {
+	id, err := forwarder.NewID()
+	if err != nil {
+		return "", err
+	}
+	timestamp := message.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	timestamp = timestamp.UTC()
+	data := keyData{
+		Name:       f.name,
+		RoutingKey: message.RoutingKey,
+		Exchange:   message.Exchange,
+		MessageID:  message.MessageID,
+		ID:         id,
+		Year:       timestamp.Format("2006"),
+		Month:      timestamp.Format("01"),
+		Day:        timestamp.Format("02"),
+		Hour:       timestamp.Format("15"),
+		Minute:     timestamp.Format("04"),
+	}
+	var key bytes.Buffer
+	if err = f.key.Execute(&key, data); err != nil {
+		return "", err
+	}
+	return key.String() + f.extension, nil
+}
+
+func compress(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(body); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,162 @@
+package s3
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io/ioutil"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
+	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+const (
+	badRequest = "Bad request"
+	bucketName = "bucket1"
+)
+
+func TestCreateForwarder(t *testing.T) {
+	entry := config.AmazonEntry{Type: "S3",
+		Name:   "s3-test",
+		Target: bucketName,
+	}
+	forwarder := CreateForwarder(entry)
+	if forwarder.Name() != entry.Name {
+		t.Errorf("wrong forwarder name, expected:%s, found: %s", entry.Name, forwarder.Name())
+	}
+	if _, ok := forwarder.(BatchForwarder); ok {
+		t.Errorf("forwarder should not batch messages")
+	}
+	entry.Batch = &config.BatchEntry{Size: 100}
+	if _, ok := CreateForwarder(entry).(BatchForwarder); !ok {
+		t.Errorf("forwarder should batch messages")
+	}
+}
+
+func TestPushMessage(t *testing.T) {
+	timestamp := time.Date(2017, 10, 1, 12, 30, 0, 0, time.UTC)
+	message := forwarder.Message{Body: `{"id":1}`, RoutingKey: "user.created", Timestamp: timestamp}
+	scenarios := []struct {
+		name    string
+		entry   config.AmazonEntry
+		message forwarder.Message
+		key     string
+		err     error
+	}{
+		{
+			name:    "empty message",
+			message: forwarder.Message{Body: ""},
+			err:     errors.New(forwarder.EmptyMessageError),
+		},
+		{
+			name:    "bad request",
+			message: forwarder.Message{Body: badRequest},
+			err:     errors.New(badRequest),
+		},
+		{
+			name:    "default key",
+			message: message,
+			key:     `^s3-test/2017/10/01/12/[0-9a-f-]{36}\.json$`,
+		},
+		{
+			name:    "default key compressed",
+			entry:   config.AmazonEntry{Gzip: true},
+			message: message,
+			key:     `^s3-test/2017/10/01/12/[0-9a-f-]{36}\.json\.gz$`,
+		},
+		{
+			name:    "custom key",
+			entry:   config.AmazonEntry{Key: "{{.RoutingKey}}/dt={{.Year}}-{{.Month}}-{{.Day}}/{{.Minute}}-{{.ID}}.json"},
+			message: message,
+			key:     `^user\.created/dt=2017-10-01/30-[0-9a-f-]{36}\.json$`,
+		},
+	}
+	for _, scenario := range scenarios {
+		t.Log("Scenario name: ", scenario.name)
+		scenario.entry.Type, scenario.entry.Name, scenario.entry.Target = "S3", "s3-test", bucketName
+		mock := &mockAmazonS3{}
+		forwarder := CreateForwarder(scenario.entry, mock).(Forwarder)
+		err := forwarder.Push(context.Background(), scenario.message)
+		if scenario.err != nil {
+			if err == nil || err.Error() != scenario.err.Error() {
+				t.Errorf("Wrong error, expecting:%v, got:%v", scenario.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Error should not occur. Error: %s", err.Error())
+			continue
+		}
+		if !regexp.MustCompile(scenario.key).MatchString(mock.keys[0]) {
+			t.Errorf("wrong object key, expected:%s, got:%s", scenario.key, mock.keys[0])
+		}
+		if mock.bodies[0] != scenario.message.Body {
+			t.Errorf("wrong object body, expected:%s, got:%s", scenario.message.Body, mock.bodies[0])
+		}
+	}
+}
+
+func TestPushBatch(t *testing.T) {
+	entry := config.AmazonEntry{Type: "S3",
+		Name:   "s3-test",
+		Target: bucketName,
+		Batch:  &config.BatchEntry{Size: 10},
+	}
+	mock := &mockAmazonS3{}
+	client := CreateForwarder(entry, mock).(BatchForwarder)
+	messages := []forwarder.Message{{Body: `{"id":1}`}, {Body: ""}, {Body: "{\"id\":2}\n"}}
+	errs := client.PushBatch(context.Background(), messages)
+	if errs[0] != nil || errs[1] == nil || errs[2] != nil {
+		t.Errorf("wrong batch results: %v", errs)
+	}
+	if len(mock.keys) != 1 || !regexp.MustCompile(`\.jsonl$`).MatchString(mock.keys[0]) {
+		t.Errorf("batch should be written as one JSON lines object, got:%v", mock.keys)
+	}
+	if expected := "{\"id\":1}\n{\"id\":2}\n"; mock.bodies[0] != expected {
+		t.Errorf("wrong object body, expected:%s, got:%s", expected, mock.bodies[0])
+	}
+
+	errs = client.PushBatch(context.Background(), []forwarder.Message{{Body: badRequest}, {Body: "abc"}})
+	if errs[0] == nil || errs[1] == nil {
+		t.Errorf("every message of failed batch should fail, got:%v", errs)
+	}
+}
+
+type mockAmazonS3 struct {
+	s3iface.S3API
+	keys   []string
+	bodies []string
+}
+
+func (m *mockAmazonS3) PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	if *input.Bucket != bucketName {
+		return nil, errors.New("Wrong bucket name")
+	}
+	body, err := ioutil.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	if input.ContentEncoding != nil && *input.ContentEncoding == gzipEncoding {
+		reader, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		if body, err = ioutil.ReadAll(reader); err != nil {
+			return nil, err
+		}
+	}
+	if bytes.HasPrefix(body, []byte(badRequest)) {
+		return nil, errors.New(badRequest)
+	}
+	m.keys = append(m.keys, *input.Key)
+	m.bodies = append(m.bodies, string(body))
+	return &s3.PutObjectOutput{}, nil
+}
@@ -1,15 +1,23 @@
 package main
 
 import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
 	"github.com/AirHelp/rabbit-amazon-forwarder/mapping"
 	"github.com/AirHelp/rabbit-amazon-forwarder/supervisor"
 	log "github.com/sirupsen/logrus"
-	"net/http"
-	"os"
 )
 
 const (
 	LogLevel = "LOG_LEVEL"
+	// DefaultShutdownTimeout time to wait for in-flight messages on shutdown
+	DefaultShutdownTimeout = 30 * time.Second
 )
 
 func main() {
@@ -23,10 +31,48 @@ func main() {
 	if err := supervisor.Start(); err != nil {
 		log.WithField("error", err.Error()).Fatal("Could not start supervisor")
 	}
-	http.HandleFunc("/restart", supervisor.Restart)
-	http.HandleFunc("/health", supervisor.Check)
-	log.Info("Starting http server")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/restart", supervisor.Restart)
+	mux.HandleFunc("/health", supervisor.Check)
+	server := &http.Server{Addr: ":8080", Handler: mux}
+	go func() {
+		log.Info("Starting http server")
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-signals
+	timeout := shutdownTimeout()
+	log.WithFields(log.Fields{
+		"signal":  sig.String(),
+		"timeout": timeout.String()}).Info("Shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := supervisor.Shutdown(ctx); err != nil {
+		log.WithField("error", err.Error()).Error("Could not stop consumers gracefully")
+	}
+	if err := server.Shutdown(ctx); err != nil {
+		log.WithField("error", err.Error()).Error("Could not stop http server gracefully")
+	}
+	log.Info("Shutdown completed")
+}
+
+func shutdownTimeout() time.Duration {
+	value := os.Getenv(config.ShutdownTimeout)
+	if value == "" {
+		return DefaultShutdownTimeout
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":                err.Error(),
+			config.ShutdownTimeout: value}).Warn("Invalid shutdown timeout, using default")
+		return DefaultShutdownTimeout
+	}
+	return timeout
 }
 
 func createLogger() {
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
+)
+
+func TestShutdownTimeout(t *testing.T) {
+	scenarios := []struct {
+		name     string
+		value    string
+		expected time.Duration
+	}{
+		{name: "default", value: "", expected: DefaultShutdownTimeout},
+		{name: "configured", value: "45s", expected: 45 * time.Second},
+		{name: "invalid", value: "soon", expected: DefaultShutdownTimeout},
+	}
+	defer os.Unsetenv(config.ShutdownTimeout)
+	for _, scenario := range scenarios {
+		t.Log("Scenario name: ", scenario.name)
+		os.Setenv(config.ShutdownTimeout, scenario.value)
+		if timeout := shutdownTimeout(); timeout != scenario.expected {
+			t.Errorf("wrong shutdown timeout, expected: %s, got: %s", scenario.expected, timeout)
+		}
+	}
+}
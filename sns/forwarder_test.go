@@ -1,12 +1,19 @@
 package sns
 
 import (
+	"context"
 	"errors"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/AirHelp/rabbit-amazon-forwarder/config"
 	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
+	"github.com/AirHelp/rabbit-amazon-forwarder/offload"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/sns"
 	"github.com/aws/aws-sdk-go/service/sns/snsiface"
 )
@@ -61,8 +68,8 @@ func TestPush(t *testing.T) {
 	}
 	for _, scenario := range scenarios {
 		t.Log("Scenario name: ", scenario.name)
-		forwarder := CreateForwarder(entry, scenario.mock)
-		err := forwarder.Push(scenario.message)
+		client := CreateForwarder(entry, scenario.mock)
+		err := client.Push(context.Background(), forwarder.Message{Body: scenario.message})
 		if scenario.err == nil && err != nil {
 			t.Errorf("Error should not occur")
 			return
@@ -76,6 +83,51 @@ func TestPush(t *testing.T) {
 	}
 }
 
+func TestPushOffloaded(t *testing.T) {
+	entry := config.AmazonEntry{Type: "SNS",
+		Name:    "sns-test",
+		Target:  "topic1",
+		Offload: &config.OffloadEntry{Bucket: "payloads", Threshold: 3},
+	}
+	mock := &mockOffloadSNS{}
+	client := CreateForwarder(entry, mock).(Forwarder)
+	client.offloader = offload.New(*entry.Offload, mockAmazonS3{})
+	if err := client.Push(context.Background(), forwarder.Message{Body: "abcd"}); err != nil {
+		t.Errorf("Error should not occur. Error: %s", err.Error())
+		return
+	}
+	if !strings.Contains(*mock.input.Message, offload.PointerClass) {
+		t.Errorf("message should be replaced with pointer, got:%s", *mock.input.Message)
+	}
+	size := mock.input.MessageAttributes[offload.PayloadSizeAttribute]
+	if size == nil || *size.StringValue != "4" {
+		t.Errorf("wrong payload size attribute, got:%v", size)
+	}
+}
+
+func TestPushTimeout(t *testing.T) {
+	entry := config.AmazonEntry{Type: "SNS",
+		Name:    "sns-test",
+		Target:  "arn",
+		Timeout: "50ms",
+	}
+	client := CreateForwarder(entry, mockHangingSNS{})
+	start := time.Now()
+	err := client.Push(context.Background(), forwarder.Message{Body: "abc"})
+	if err != context.DeadlineExceeded {
+		t.Errorf("push should time out, got: %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("push should be cancelled after timeout")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = client.Push(ctx, forwarder.Message{Body: "abc"}); err != context.Canceled {
+		t.Errorf("push should be cancelled, got: %v", err)
+	}
+}
+
 type mockAmazonSNS struct {
 	snsiface.SNSAPI
 	resp    sns.PublishOutput
@@ -83,7 +135,7 @@ type mockAmazonSNS struct {
 	message string
 }
 
-func (m mockAmazonSNS) Publish(input *sns.PublishInput) (*sns.PublishOutput, error) {
+func (m mockAmazonSNS) PublishWithContext(ctx aws.Context, input *sns.PublishInput, opts ...request.Option) (*sns.PublishOutput, error) {
 	if *input.TargetArn != m.topic {
 		return nil, errors.New("Wrong topic name")
 	}
@@ -95,3 +147,30 @@ func (m mockAmazonSNS) Publish(input *sns.PublishInput) (*sns.PublishOutput, err
 	}
 	return &m.resp, nil
 }
+
+type mockOffloadSNS struct {
+	snsiface.SNSAPI
+	input *sns.PublishInput
+}
+
+func (m *mockOffloadSNS) PublishWithContext(ctx aws.Context, input *sns.PublishInput, opts ...request.Option) (*sns.PublishOutput, error) {
+	m.input = input
+	return &sns.PublishOutput{MessageId: aws.String("messageId")}, nil
+}
+
+type mockHangingSNS struct {
+	snsiface.SNSAPI
+}
+
+func (m mockHangingSNS) PublishWithContext(ctx aws.Context, input *sns.PublishInput, opts ...request.Option) (*sns.PublishOutput, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+type mockAmazonS3 struct {
+	s3iface.S3API
+}
+
+func (m mockAmazonS3) PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	return &s3.PutObjectOutput{}, nil
+}
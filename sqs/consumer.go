@@ -0,0 +1,252 @@
+package sqs
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
+	"github.com/AirHelp/rabbit-amazon-forwarder/consumer"
+	"github.com/AirHelp/rabbit-amazon-forwarder/filter"
+	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
+	"github.com/AirHelp/rabbit-amazon-forwarder/transform"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+const (
+	// DefaultWaitTimeSeconds long polling time of a single receive call
+	DefaultWaitTimeSeconds = 20
+	// DefaultMaxMessages number of messages received in a single call
+	DefaultMaxMessages = 10
+	// ReceiveRetryInterval time to wait after failed receive call
+	ReceiveRetryInterval   = 10 * time.Second
+	sentTimestampAttribute = "SentTimestamp"
+)
+
+// Consumer long-polls SQS queue and forwards received messages
+type Consumer struct {
+	name              string
+	sqsClient         sqsiface.SQSAPI
+	queue             string
+	waitTimeSeconds   int64
+	maxMessages       int64
+	visibilityTimeout int64
+	transformer       *transform.Transformer
+	filter            *filter.Filter
+	delivery          string
+}
+
+// CreateConsumer creates SQS consumer. Queue is the queue URL, connection optionally overrides SQS endpoint
+func CreateConsumer(entry config.RabbitEntry, sqsClient ...sqsiface.SQSAPI) consumer.Client {
+	var client sqsiface.SQSAPI
+	if len(sqsClient) > 0 {
+		client = sqsClient[0]
+	} else {
+		awsConfig := aws.NewConfig()
+		if len(entry.ConnectionURLs) > 0 {
+			awsConfig = awsConfig.WithEndpoint(entry.ConnectionURLs[0])
+		}
+		client = sqs.New(session.Must(session.NewSession(awsConfig)))
+	}
+	waitTimeSeconds := entry.WaitTimeSeconds
+	if waitTimeSeconds <= 0 {
+		waitTimeSeconds = DefaultWaitTimeSeconds
+	}
+	maxMessages := entry.MaxMessages
+	if maxMessages <= 0 {
+		maxMessages = DefaultMaxMessages
+	}
+	var transformer *transform.Transformer
+	if entry.Transform != nil {
+		var err error
+		if transformer, err = transform.New(*entry.Transform); err != nil {
+			log.WithFields(log.Fields{
+				"consumerName": entry.Name,
+				"error":        err.Error()}).Fatal("Could not create message transformer")
+		}
+	}
+	var messageFilter *filter.Filter
+	if len(entry.Filter) > 0 {
+		var err error
+		if messageFilter, err = filter.New(entry.Filter); err != nil {
+			log.WithFields(log.Fields{
+				"consumerName": entry.Name,
+				"error":        err.Error()}).Fatal("Could not create message filter")
+		}
+	}
+	delivery, err := consumer.DeliveryMode(entry.Delivery)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"consumerName": entry.Name,
+			"error":        err.Error()}).Fatal("Invalid delivery mode")
+	}
+	return Consumer{
+		name:              entry.Name,
+		sqsClient:         client,
+		queue:             entry.QueueName,
+		waitTimeSeconds:   waitTimeSeconds,
+		maxMessages:       maxMessages,
+		visibilityTimeout: entry.VisibilityTimeout,
+		transformer:       transformer,
+		filter:            messageFilter,
+		delivery:          delivery,
+	}
+}
+
+// Name consumer name
+func (c Consumer) Name() string {
+	return c.name
+}
+
+// Status reports delivery mode and number of filtered out messages
+func (c Consumer) Status() consumer.Status {
+	return consumer.Status{Delivery: c.delivery, DroppedMessages: c.filter.Dropped()}
+}
+
+// Start starts receiving messages from SQS queue
+func (c Consumer) Start(ctx context.Context, client forwarder.Client, check chan bool, stop chan bool) error {
+	forwarderName := client.Name()
+	log.WithFields(log.Fields{
+		"consumerName":  c.Name(),
+		"forwarderName": forwarderName,
+		"queueName":     c.queue}).Info("Started forwarding messages")
+	// receiving ends with the consumer, in-flight push is cancelled only by the context
+	receiveCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	messages := make(chan *sqs.Message)
+	go c.receive(receiveCtx, messages)
+	for {
+		select {
+		case m := <-messages:
+			c.forward(ctx, client, m)
+		case <-check:
+			log.WithField("forwarderName", forwarderName).Info("Checking")
+		case <-stop:
+			log.WithField("forwarderName", forwarderName).Info("Closing")
+			return nil
+		case <-ctx.Done():
+			log.WithField("forwarderName", forwarderName).Info("Closing, shutdown timed out")
+			return ctx.Err()
+		}
+	}
+}
+
+// receive long-polls the queue until context is cancelled
+func (c Consumer) receive(ctx context.Context, messages chan<- *sqs.Message) {
+	params := &sqs.ReceiveMessageInput{
+		QueueUrl:              aws.String(c.queue),
+		WaitTimeSeconds:       aws.Int64(c.waitTimeSeconds),
+		MaxNumberOfMessages:   aws.Int64(c.maxMessages),
+		AttributeNames:        []*string{aws.String(sentTimestampAttribute)},
+		MessageAttributeNames: []*string{aws.String(sqs.QueueAttributeNameAll)},
+	}
+	if c.visibilityTimeout > 0 {
+		params.VisibilityTimeout = aws.Int64(c.visibilityTimeout)
+	}
+	for {
+		resp, err := c.sqsClient.ReceiveMessageWithContext(ctx, params)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"consumerName": c.Name(),
+				"error":        err.Error()}).Error("Could not receive messages")
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(ReceiveRetryInterval):
+			}
+			continue
+		}
+		for _, m := range resp.Messages {
+			select {
+			case messages <- m:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// forward forwards a single SQS message and deletes it from the queue on success.
+// Failed messages stay in the queue and are redelivered after visibility timeout.
+// In at-most-once mode message is deleted before forwarding
+func (c Consumer) forward(ctx context.Context, client forwarder.Client, m *sqs.Message) {
+	forwarderName := client.Name()
+	atMostOnce := c.delivery == consumer.AtMostOnce
+	if atMostOnce {
+		c.delete(m, forwarderName)
+	}
+	message := newMessage(m)
+	if !c.filter.Match(message) {
+		log.WithFields(log.Fields{
+			"consumerName":    c.Name(),
+			"messageID":       message.MessageID,
+			"droppedMessages": c.filter.Dropped()}).Debug("Message filtered out")
+		if !atMostOnce {
+			c.delete(m, forwarderName)
+		}
+		return
+	}
+	log.WithFields(log.Fields{
+		"consumerName": c.Name(),
+		"messageID":    message.MessageID}).Info("Message to forward")
+	body, err := c.transformer.Apply(message)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"consumerName": c.Name(),
+			"error":        err.Error()}).Error("Could not transform message")
+		return
+	}
+	message.Body = body
+	if err := client.Push(ctx, message); err != nil {
+		log.WithFields(log.Fields{
+			"forwarderName": forwarderName,
+			"error":         err.Error(),
+			"messageID":     message.MessageID}).Error("Could not forward message")
+		return
+	}
+	if !atMostOnce {
+		c.delete(m, forwarderName)
+	}
+}
+
+func (c Consumer) delete(m *sqs.Message, forwarderName string) {
+	params := &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(c.queue),
+		ReceiptHandle: m.ReceiptHandle,
+	}
+	if _, err := c.sqsClient.DeleteMessage(params); err != nil {
+		log.WithFields(log.Fields{
+			"forwarderName": forwarderName,
+			"error":         err.Error(),
+			"messageID":     aws.StringValue(m.MessageId)}).Error("Could not delete message")
+	}
+}
+
+func newMessage(m *sqs.Message) forwarder.Message {
+	timestamp := time.Now()
+	if sent, ok := m.Attributes[sentTimestampAttribute]; ok {
+		if millis, err := strconv.ParseInt(aws.StringValue(sent), 10, 64); err == nil {
+			timestamp = time.Unix(0, millis*int64(time.Millisecond))
+		}
+	}
+	headers := make(map[string]interface{})
+	for name, attribute := range m.MessageAttributes {
+		if attribute.StringValue != nil {
+			headers[name] = aws.StringValue(attribute.StringValue)
+		}
+	}
+	return forwarder.Message{
+		Body:      aws.StringValue(m.Body),
+		MessageID: aws.StringValue(m.MessageId),
+		Timestamp: timestamp,
+		Headers:   headers,
+	}
+}
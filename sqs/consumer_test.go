@@ -0,0 +1,234 @@
+package sqs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
+	"github.com/AirHelp/rabbit-amazon-forwarder/consumer"
+	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+const queueURL = "http://localhost:9324/queue/source"
+
+func TestCreateConsumer(t *testing.T) {
+	entry := config.RabbitEntry{Type: "SQS",
+		Name:      "sqs-source",
+		QueueName: queueURL,
+	}
+	consumer := CreateConsumer(entry, &mockReceiveSQS{}).(Consumer)
+	if consumer.Name() != entry.Name {
+		t.Errorf("wrong consumer name, expected:%s, found: %s", entry.Name, consumer.Name())
+	}
+	if consumer.waitTimeSeconds != DefaultWaitTimeSeconds {
+		t.Errorf("wrong wait time, expected:%d, found: %d", DefaultWaitTimeSeconds, consumer.waitTimeSeconds)
+	}
+	if consumer.maxMessages != DefaultMaxMessages {
+		t.Errorf("wrong max messages, expected:%d, found: %d", DefaultMaxMessages, consumer.maxMessages)
+	}
+}
+
+func TestConsume(t *testing.T) {
+	scenarios := []struct {
+		name     string
+		delivery string
+		message  *sqs.Message
+		pushErr  error
+		deleted  bool
+	}{
+		{
+			name:    "forwarded message is deleted",
+			message: &sqs.Message{Body: aws.String("abc"), MessageId: aws.String("id-1"), ReceiptHandle: aws.String("handle-1")},
+			deleted: true,
+		},
+		{
+			name:    "failed message stays in queue",
+			message: &sqs.Message{Body: aws.String("abc"), MessageId: aws.String("id-2"), ReceiptHandle: aws.String("handle-2")},
+			pushErr: errors.New("not confirmed"),
+			deleted: false,
+		},
+		{
+			name:     "at-most-once message is deleted before forwarding",
+			delivery: consumer.AtMostOnce,
+			message:  &sqs.Message{Body: aws.String("abc"), MessageId: aws.String("id-3"), ReceiptHandle: aws.String("handle-3")},
+			pushErr:  errors.New("not confirmed"),
+			deleted:  true,
+		},
+	}
+	for _, scenario := range scenarios {
+		t.Log("Scenario name: ", scenario.name)
+		entry := config.RabbitEntry{Type: "SQS",
+			Name:      "sqs-source",
+			QueueName: queueURL,
+			Delivery:  scenario.delivery,
+		}
+		mock := &mockReceiveSQS{messages: []*sqs.Message{scenario.message}}
+		client := &mockForwarder{err: scenario.pushErr, pushed: make(chan string, 1)}
+		check := make(chan bool)
+		stop := make(chan bool)
+		done := make(chan error)
+		go func() { done <- CreateConsumer(entry, mock).Start(context.Background(), client, check, stop) }()
+		select {
+		case body := <-client.pushed:
+			if body != aws.StringValue(scenario.message.Body) {
+				t.Errorf("wrong message forwarded, expected:%s, found: %s", aws.StringValue(scenario.message.Body), body)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("message was not forwarded")
+		}
+		check <- true
+		stop <- true
+		if err := <-done; err != nil {
+			t.Errorf("consumer should stop without error, got: %v", err)
+		}
+		if deleted := len(mock.deletedHandles()) == 1; deleted != scenario.deleted {
+			t.Errorf("wrong delete state, expected:%t, found: %t", scenario.deleted, deleted)
+		}
+	}
+}
+
+func TestStopWaitsForPush(t *testing.T) {
+	entry := config.RabbitEntry{Type: "SQS", Name: "sqs-source", QueueName: queueURL}
+	mock := &mockReceiveSQS{messages: []*sqs.Message{{Body: aws.String("abc"), MessageId: aws.String("id-1"), ReceiptHandle: aws.String("handle-1")}}}
+	client := &mockBlockingForwarder{mockForwarder{pushed: make(chan string, 1)}, make(chan struct{})}
+	stop := make(chan bool)
+	done := make(chan error)
+	go func() { done <- CreateConsumer(entry, mock).Start(context.Background(), client, make(chan bool), stop) }()
+	select {
+	case <-client.pushed:
+	case <-time.After(time.Second):
+		t.Fatalf("message was not forwarded")
+	}
+	stopped := make(chan struct{})
+	go func() {
+		stop <- true
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatalf("stop should not be received while message is pushed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(client.release)
+	if err := <-done; err != nil {
+		t.Errorf("consumer should stop without error, got: %v", err)
+	}
+	if len(mock.deletedHandles()) != 1 {
+		t.Errorf("message forwarded after stop should be deleted")
+	}
+}
+
+func TestShutdownTimeoutInterruptsPush(t *testing.T) {
+	entry := config.RabbitEntry{Type: "SQS", Name: "sqs-source", QueueName: queueURL}
+	mock := &mockReceiveSQS{messages: []*sqs.Message{{Body: aws.String("abc"), MessageId: aws.String("id-1"), ReceiptHandle: aws.String("handle-1")}}}
+	client := &mockBlockingForwarder{mockForwarder{pushed: make(chan string, 1)}, make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- CreateConsumer(entry, mock).Start(ctx, client, make(chan bool), make(chan bool)) }()
+	select {
+	case <-client.pushed:
+	case <-time.After(time.Second):
+		t.Fatalf("message was not forwarded")
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("consumer should stop with context error, got: %v", err)
+	}
+	if len(mock.deletedHandles()) != 0 {
+		t.Errorf("interrupted message should stay in queue")
+	}
+}
+
+func TestNewMessage(t *testing.T) {
+	m := &sqs.Message{
+		Body:       aws.String(`{"a":1}`),
+		MessageId:  aws.String("id"),
+		Attributes: map[string]*string{sentTimestampAttribute: aws.String("1500000000000")},
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"origin": {DataType: aws.String("String"), StringValue: aws.String("billing")},
+			"blob":   {DataType: aws.String("Binary"), BinaryValue: []byte("x")},
+		},
+	}
+	message := newMessage(m)
+	if message.MessageID != "id" || message.Body != `{"a":1}` {
+		t.Errorf("wrong message, found: %+v", message)
+	}
+	if !message.Timestamp.Equal(time.Unix(1500000000, 0)) {
+		t.Errorf("wrong timestamp, found: %s", message.Timestamp)
+	}
+	if message.Headers["origin"] != "billing" {
+		t.Errorf("wrong origin header, found: %v", message.Headers["origin"])
+	}
+	if _, ok := message.Headers["blob"]; ok {
+		t.Errorf("binary attribute should not be mapped to header")
+	}
+}
+
+type mockReceiveSQS struct {
+	sqsiface.SQSAPI
+	sync.Mutex
+	messages []*sqs.Message
+	deleted  []string
+}
+
+func (m *mockReceiveSQS) ReceiveMessageWithContext(ctx aws.Context, input *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	m.Lock()
+	messages := m.messages
+	m.messages = nil
+	m.Unlock()
+	if len(messages) == 0 {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return &sqs.ReceiveMessageOutput{Messages: messages}, nil
+}
+
+func (m *mockReceiveSQS) DeleteMessage(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+	m.Lock()
+	defer m.Unlock()
+	m.deleted = append(m.deleted, aws.StringValue(input.ReceiptHandle))
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (m *mockReceiveSQS) deletedHandles() []string {
+	m.Lock()
+	defer m.Unlock()
+	return m.deleted
+}
+
+type mockForwarder struct {
+	err    error
+	pushed chan string
+}
+
+func (f *mockForwarder) Name() string {
+	return "rabbitmq-destination"
+}
+
+func (f *mockForwarder) Push(ctx context.Context, message forwarder.Message) error {
+	f.pushed <- message.Body
+	return f.err
+}
+
+// mockBlockingForwarder push waits until it is released or its context is cancelled
+type mockBlockingForwarder struct {
+	mockForwarder
+	release chan struct{}
+}
+
+func (f *mockBlockingForwarder) Push(ctx context.Context, message forwarder.Message) error {
+	f.pushed <- message.Body
+	select {
+	case <-f.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
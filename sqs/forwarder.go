@@ -1,11 +1,15 @@
 package sqs
 
 import (
+	"context"
 	"errors"
+	"time"
+
 	log "github.com/sirupsen/logrus"
 
 	"github.com/AirHelp/rabbit-amazon-forwarder/config"
 	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
+	"github.com/AirHelp/rabbit-amazon-forwarder/offload"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sqs"
@@ -22,6 +26,8 @@ type Forwarder struct {
 	name      string
 	sqsClient sqsiface.SQSAPI
 	queue     string
+	offloader *offload.Offloader
+	timeout   time.Duration
 }
 
 // CreateForwarder creates instance of forwarder
@@ -32,7 +38,17 @@ func CreateForwarder(entry config.AmazonEntry, sqsClient ...sqsiface.SQSAPI) for
 	} else {
 		client = sqs.New(session.Must(session.NewSession()))
 	}
-	forwarder := Forwarder{entry.Name, client, entry.Target}
+	var offloader *offload.Offloader
+	if entry.Offload != nil {
+		offloader = offload.New(*entry.Offload)
+	}
+	timeout, err := forwarder.Timeout(entry.Timeout, forwarder.DefaultTimeout)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"forwarderName": entry.Name,
+			"error":         err.Error()}).Fatal("Invalid push timeout")
+	}
+	forwarder := Forwarder{entry.Name, client, entry.Target, offloader, timeout}
 	log.WithField("forwarderName", forwarder.Name()).Info("Created forwarder")
 	return forwarder
 }
@@ -42,17 +58,35 @@ func (f Forwarder) Name() string {
 	return f.name
 }
 
-// Push pushes message to forwarding infrastructure
-func (f Forwarder) Push(message string) error {
+// Push pushes message to forwarding infrastructure, push is cancelled when context is done or timeout passes
+func (f Forwarder) Push(ctx context.Context, msg forwarder.Message) error {
+	message := msg.Body
 	if message == "" {
 		return errors.New(forwarder.EmptyMessageError)
 	}
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+	body, offloaded, err := f.offloader.Offload(ctx, message)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"forwarderName": f.Name(),
+			"error":         err.Error()}).Error("Could not offload message payload")
+		return err
+	}
 	params := &sqs.SendMessageInput{
-		MessageBody: aws.String(message), // Required
+		MessageBody: aws.String(body),    // Required
 		QueueUrl:    aws.String(f.queue), // Required
 	}
+	if offloaded {
+		params.MessageAttributes = map[string]*sqs.MessageAttributeValue{
+			offload.PayloadSizeAttribute: {
+				DataType:    aws.String("Number"),
+				StringValue: aws.String(offload.PayloadSize(message)),
+			},
+		}
+	}
 
-	resp, err := f.sqsClient.SendMessage(params)
+	resp, err := f.sqsClient.SendMessageWithContext(ctx, params)
 
 	if err != nil {
 		log.WithFields(log.Fields{
@@ -1,12 +1,18 @@
 package sqs
 
 import (
+	"context"
 	"errors"
+	"strings"
 	"testing"
 
 	"github.com/AirHelp/rabbit-amazon-forwarder/config"
 	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
+	"github.com/AirHelp/rabbit-amazon-forwarder/offload"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 )
@@ -61,8 +67,8 @@ func TestPush(t *testing.T) {
 	}
 	for _, scenario := range scenarios {
 		t.Log("Scenario name: ", scenario.name)
-		forwarder := CreateForwarder(entry, scenario.mock)
-		err := forwarder.Push(scenario.message)
+		client := CreateForwarder(entry, scenario.mock)
+		err := client.Push(context.Background(), forwarder.Message{Body: scenario.message})
 		if scenario.err == nil && err != nil {
 			t.Errorf("Error should not occur")
 			return
@@ -76,6 +82,28 @@ func TestPush(t *testing.T) {
 	}
 }
 
+func TestPushOffloaded(t *testing.T) {
+	entry := config.AmazonEntry{Type: "SQS",
+		Name:    "sqs-test",
+		Target:  "queue1",
+		Offload: &config.OffloadEntry{Bucket: "payloads", Threshold: 3},
+	}
+	mock := &mockOffloadSQS{}
+	client := CreateForwarder(entry, mock).(Forwarder)
+	client.offloader = offload.New(*entry.Offload, mockAmazonS3{})
+	if err := client.Push(context.Background(), forwarder.Message{Body: "abcd"}); err != nil {
+		t.Errorf("Error should not occur. Error: %s", err.Error())
+		return
+	}
+	if !strings.Contains(*mock.input.MessageBody, offload.PointerClass) {
+		t.Errorf("message should be replaced with pointer, got:%s", *mock.input.MessageBody)
+	}
+	size := mock.input.MessageAttributes[offload.PayloadSizeAttribute]
+	if size == nil || *size.StringValue != "4" {
+		t.Errorf("wrong payload size attribute, got:%v", size)
+	}
+}
+
 type mockAmazonSQS struct {
 	sqsiface.SQSAPI
 	resp    sqs.SendMessageOutput
@@ -83,7 +111,7 @@ type mockAmazonSQS struct {
 	message string
 }
 
-func (m mockAmazonSQS) SendMessage(input *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+func (m mockAmazonSQS) SendMessageWithContext(ctx aws.Context, input *sqs.SendMessageInput, opts ...request.Option) (*sqs.SendMessageOutput, error) {
 	if *input.QueueUrl != m.queue {
 		return nil, errors.New("Wrong queue name")
 	}
@@ -95,3 +123,21 @@ func (m mockAmazonSQS) SendMessage(input *sqs.SendMessageInput) (*sqs.SendMessag
 	}
 	return &m.resp, nil
 }
+
+type mockOffloadSQS struct {
+	sqsiface.SQSAPI
+	input *sqs.SendMessageInput
+}
+
+func (m *mockOffloadSQS) SendMessageWithContext(ctx aws.Context, input *sqs.SendMessageInput, opts ...request.Option) (*sqs.SendMessageOutput, error) {
+	m.input = input
+	return &sqs.SendMessageOutput{MessageId: aws.String("messageId")}, nil
+}
+
+type mockAmazonS3 struct {
+	s3iface.S3API
+}
+
+func (m mockAmazonS3) PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	return &s3.PutObjectOutput{}, nil
+}
@@ -1,6 +1,7 @@
 package supervisor
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -9,6 +10,7 @@ import (
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/AirHelp/rabbit-amazon-forwarder/consumer"
 	"github.com/AirHelp/rabbit-amazon-forwarder/mapping"
 )
 
@@ -19,37 +21,61 @@ const (
 	acceptHeader = "Accept"
 	contentType  = "Content-Type"
 	acceptAll    = "*/*"
+	// checkTimeout time to wait for consumer to receive health check
+	checkTimeout = 500 * time.Millisecond
+	// restartTimeout time to wait for consumers to stop before restart
+	restartTimeout = 30 * time.Second
+	notStopped     = "consumers did not stop in time, restart skipped"
 )
 
 type response struct {
-	Healthy bool   `json:"healthy"`
-	Message string `json:"message"`
+	Healthy   bool             `json:"healthy"`
+	Message   string           `json:"message"`
+	Consumers []consumerStatus `json:"consumers,omitempty"`
+}
+
+type consumerStatus struct {
+	Consumer  string `json:"consumer"`
+	Forwarder string `json:"forwarder"`
+	consumer.Status
 }
 
 type consumerChannel struct {
 	name  string
 	check chan bool
 	stop  chan bool
+	done  chan struct{}
+	// cancel cancels pushes of the consumer
+	cancel context.CancelFunc
 }
 
 // Client supervisor client
 type Client struct {
-	mappings  []mapping.ConsumerForwarderMapping
-	consumers map[string]*consumerChannel
+	mappings       []mapping.ConsumerForwarderMapping
+	consumers      map[string]*consumerChannel
+	restartTimeout time.Duration
 }
 
 // New client for supervisor
 func New(consumerForwarderMapping []mapping.ConsumerForwarderMapping) Client {
-	return Client{mappings: consumerForwarderMapping}
+	return Client{mappings: consumerForwarderMapping, restartTimeout: restartTimeout}
 }
 
 // Start starts supervisor
 func (c *Client) Start() error {
 	c.consumers = make(map[string]*consumerChannel)
 	for _, mappingEntry := range c.mappings {
-		channel := makeConsumerChannel(mappingEntry.Forwarder.Name())
+		ctx, cancel := context.WithCancel(context.Background())
+		channel := makeConsumerChannel(mappingEntry.Forwarder.Name(), cancel)
 		c.consumers[mappingEntry.Forwarder.Name()] = channel
-		go mappingEntry.Consumer.Start(mappingEntry.Forwarder, channel.check, channel.stop)
+		go func(mappingEntry mapping.ConsumerForwarderMapping) {
+			defer close(channel.done)
+			if err := mappingEntry.Consumer.Start(ctx, mappingEntry.Forwarder, channel.check, channel.stop); err != nil {
+				log.WithFields(log.Fields{
+					"consumerName": mappingEntry.Consumer.Name(),
+					"error":        err.Error()}).Error("Consumer stopped")
+			}
+		}(mappingEntry)
 		log.WithFields(log.Fields{
 			"consumerName":  mappingEntry.Consumer.Name(),
 			"forwarderName": mappingEntry.Forwarder.Name()}).Info("Started consumer with forwarder")
@@ -72,7 +98,13 @@ func (c *Client) Check(w http.ResponseWriter, r *http.Request) {
 			stopped = stopped + 1
 			continue
 		}
-		consumer.check <- true
+		// consumer which stopped or reconnects does not receive check
+		select {
+		case consumer.check <- true:
+		case <-time.After(checkTimeout):
+			stopped = stopped + 1
+			continue
+		}
 		time.Sleep(500 * time.Millisecond)
 		if len(consumer.check) > 0 {
 			stopped = stopped + 1
@@ -80,39 +112,94 @@ func (c *Client) Check(w http.ResponseWriter, r *http.Request) {
 	}
 	if stopped > 0 {
 		message := fmt.Sprintf("Number of failed consumers: %d", stopped)
-		errorResponse(w, message)
+		errorResponse(w, message, c.statuses())
 		return
 	}
-	successResponse(w)
+	successResponse(w, c.statuses())
+}
+
+// statuses collects status of consumers which report it
+func (c *Client) statuses() []consumerStatus {
+	var statuses []consumerStatus
+	for _, mappingEntry := range c.mappings {
+		if statusClient, ok := mappingEntry.Consumer.(consumer.StatusClient); ok {
+			statuses = append(statuses, consumerStatus{
+				Consumer:  mappingEntry.Consumer.Name(),
+				Forwarder: mappingEntry.Forwarder.Name(),
+				Status:    statusClient.Status(),
+			})
+		}
+	}
+	return statuses
 }
 
-// Restart restarts every consumer
+// Restart restarts every consumer. Consumers are not started again while any of the old ones
+// is still running, otherwise the same queue would be consumed twice
 func (c *Client) Restart(w http.ResponseWriter, r *http.Request) {
-	c.stop()
+	if err := c.stop(); err != nil {
+		log.WithField("error", err.Error()).Error("Could not stop consumers, restart skipped")
+		errorResponse(w, notStopped, nil)
+		return
+	}
 	if err := c.Start(); err != nil {
 		log.Error(err)
-		errorResponse(w, "")
+		errorResponse(w, "", nil)
 		return
 	}
-	successResponse(w)
+	successResponse(w, nil)
 }
 
-func (c *Client) stop() {
+// Shutdown stops every consumer and waits until in-flight messages are forwarded and acknowledged
+// or the context is done
+func (c *Client) Shutdown(ctx context.Context) error {
+	log.Info("Stopping consumers")
+	defer func() {
+		for _, consumer := range c.consumers {
+			consumer.cancel()
+		}
+	}()
+	for _, consumer := range c.consumers {
+		go func(consumer *consumerChannel) {
+			select {
+			case consumer.stop <- true:
+			case <-consumer.done:
+			}
+		}(consumer)
+	}
 	for _, consumer := range c.consumers {
-		consumer.stop <- true
+		select {
+		case <-consumer.done:
+		case <-ctx.Done():
+			log.WithField("forwarderName", consumer.name).Warn("Consumer did not stop in time")
+			return ctx.Err()
+		}
 	}
+	log.Info("All consumers stopped")
+	return nil
+}
+
+func (c *Client) stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.restartTimeout)
+	defer cancel()
+	return c.Shutdown(ctx)
 }
 
-func makeConsumerChannel(name string) *consumerChannel {
+func makeConsumerChannel(name string, cancel context.CancelFunc) *consumerChannel {
 	check := make(chan bool)
 	stop := make(chan bool)
-	return &consumerChannel{name: name, check: check, stop: stop}
+	done := make(chan struct{})
+	return &consumerChannel{name: name, check: check, stop: stop, done: done, cancel: cancel}
 }
 
-func errorResponse(w http.ResponseWriter, message string) {
+func errorResponse(w http.ResponseWriter, message string, statuses []consumerStatus) {
 	w.Header().Set(contentType, jsonType)
 	w.WriteHeader(500)
-	w.Write([]byte(message))
+	bytes, err := json.Marshal(response{Healthy: false, Message: message, Consumers: statuses})
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	w.Write(bytes)
 }
 
 func notAcceptableResponse(w http.ResponseWriter) {
@@ -127,10 +214,10 @@ func notAcceptableResponse(w http.ResponseWriter) {
 	w.Write(bytes)
 }
 
-func successResponse(w http.ResponseWriter) {
+func successResponse(w http.ResponseWriter, statuses []consumerStatus) {
 	w.Header().Set(contentType, jsonType)
 	w.WriteHeader(200)
-	bytes, err := json.Marshal(response{Healthy: true, Message: success})
+	bytes, err := json.Marshal(response{Healthy: true, Message: success, Consumers: statuses})
 	if err != nil {
 		log.Error(err)
 		w.WriteHeader(200)
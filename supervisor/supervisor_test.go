@@ -1,12 +1,15 @@
 package supervisor
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/AirHelp/rabbit-amazon-forwarder/consumer"
 	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
 	"github.com/AirHelp/rabbit-amazon-forwarder/mapping"
 )
@@ -48,6 +51,48 @@ func TestRestart(t *testing.T) {
 	}
 }
 
+func TestRestartWithHangingConsumer(t *testing.T) {
+	hanging := []mapping.ConsumerForwarderMapping{
+		{Consumer: MockStoppingConsumer{"rabbit", 300 * time.Millisecond}, Forwarder: MockSNSForwarder{"sns"}},
+	}
+	supervisor := New(hanging)
+	supervisor.restartTimeout = 100 * time.Millisecond
+	if err := supervisor.Start(); err != nil {
+		t.Error("could not start supervised consumer->forwader pairs, error: ", err.Error())
+	}
+	previous := supervisor.consumers["sns"]
+	req, err := http.NewRequest("GET", "/restart", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(supervisor.Restart).ServeHTTP(rr, req)
+
+	if rr.Code != 500 {
+		t.Errorf("wrong status code, expected:%d, got:%d", 500, rr.Code)
+	}
+	var res response
+	if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil {
+		t.Fatalf("could not parse response: %s", err.Error())
+	}
+	if res.Healthy || res.Message != notStopped {
+		t.Errorf("wrong response, got:%+v", res)
+	}
+	if supervisor.consumers["sns"] != previous {
+		t.Errorf("consumers should not be started while old ones are running")
+	}
+
+	<-previous.done
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(supervisor.Restart).ServeHTTP(rr, req)
+	if rr.Code != 200 {
+		t.Errorf("wrong status code after consumers stopped, expected:%d, got:%d", 200, rr.Code)
+	}
+	if supervisor.consumers["sns"] == previous {
+		t.Errorf("consumers should be started again")
+	}
+}
+
 func TestCheck(t *testing.T) {
 	successJSON := response{Healthy: true, Message: success}
 	sucessMessage, err := json.Marshal(successJSON)
@@ -97,6 +142,96 @@ func TestCheck(t *testing.T) {
 	}
 }
 
+func TestCheckStatus(t *testing.T) {
+	consumers := []mapping.ConsumerForwarderMapping{
+		{Consumer: MockStatusConsumer{MockRabbitConsumer{"rabbit"}}, Forwarder: MockSNSForwarder{"sns"}},
+		{Consumer: MockRabbitConsumer{"rabbit"}, Forwarder: MockSQSForwarder{"sqs"}},
+	}
+	supervisor := New(consumers)
+	if err := supervisor.Start(); err != nil {
+		t.Error("could not start supervised consumer->forwader pairs, error: ", err.Error())
+	}
+	req, err := http.NewRequest("GET", "/check", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(supervisor.Check).ServeHTTP(rr, req)
+
+	var res response
+	if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil {
+		t.Fatalf("could not parse response: %s", err.Error())
+	}
+	if len(res.Consumers) != 1 {
+		t.Fatalf("wrong number of consumer statuses, expected:%d, got:%d", 1, len(res.Consumers))
+	}
+	status := res.Consumers[0]
+	if status.Forwarder != "sns" || status.Delivery != consumer.AtMostOnce || status.DroppedMessages != 2 {
+		t.Errorf("wrong consumer status, got:%+v", status)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	stopping := []mapping.ConsumerForwarderMapping{
+		{Consumer: MockStoppingConsumer{"rabbit", 0}, Forwarder: MockSNSForwarder{"sns"}},
+		{Consumer: MockStoppingConsumer{"rabbit", 0}, Forwarder: MockSQSForwarder{"sqs"}},
+	}
+	supervisor := New(stopping)
+	if err := supervisor.Start(); err != nil {
+		t.Error("could not start supervised consumer->forwader pairs, error: ", err.Error())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := supervisor.Shutdown(ctx); err != nil {
+		t.Errorf("consumers should stop, got error: %s", err.Error())
+	}
+
+	hanging := []mapping.ConsumerForwarderMapping{
+		{Consumer: MockStoppingConsumer{"rabbit", time.Minute}, Forwarder: MockSNSForwarder{"sns"}},
+	}
+	supervisor = New(hanging)
+	if err := supervisor.Start(); err != nil {
+		t.Error("could not start supervised consumer->forwader pairs, error: ", err.Error())
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := supervisor.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("shutdown should exceed deadline, got: %v", err)
+	}
+}
+
+func TestShutdownWaitsForPush(t *testing.T) {
+	scenarios := []struct {
+		name     string
+		push     time.Duration
+		deadline time.Duration
+		err      error
+		pushErr  error
+	}{
+		{name: "push shorter than deadline", push: 200 * time.Millisecond, deadline: time.Second, err: nil, pushErr: nil},
+		{name: "push longer than deadline", push: time.Second, deadline: 100 * time.Millisecond, err: context.DeadlineExceeded, pushErr: context.Canceled},
+	}
+	for _, scenario := range scenarios {
+		t.Log("Scenario name: ", scenario.name)
+		pushing := MockPushingConsumer{"rabbit", make(chan struct{}), make(chan error, 1)}
+		supervisor := New([]mapping.ConsumerForwarderMapping{
+			{Consumer: pushing, Forwarder: MockSlowForwarder{"sns", scenario.push}},
+		})
+		if err := supervisor.Start(); err != nil {
+			t.Error("could not start supervised consumer->forwader pairs, error: ", err.Error())
+		}
+		<-pushing.started
+		ctx, cancel := context.WithTimeout(context.Background(), scenario.deadline)
+		if err := supervisor.Shutdown(ctx); err != scenario.err {
+			t.Errorf("wrong shutdown result, expected: %v, got: %v", scenario.err, err)
+		}
+		cancel()
+		if err := <-pushing.pushed; err != scenario.pushErr {
+			t.Errorf("wrong push result, expected: %v, got: %v", scenario.pushErr, err)
+		}
+	}
+}
+
 func prepareConsumers() []mapping.ConsumerForwarderMapping {
 	var consumers []mapping.ConsumerForwarderMapping
 	consumers = append(consumers, mapping.ConsumerForwarderMapping{Consumer: MockRabbitConsumer{"rabbit"}, Forwarder: MockSNSForwarder{"sns"}})
@@ -109,6 +244,23 @@ type MockRabbitConsumer struct {
 	name string
 }
 
+// MockStoppingConsumer finishes in-flight message for drain time after stop signal
+type MockStoppingConsumer struct {
+	name  string
+	drain time.Duration
+}
+
+// MockPushingConsumer pushes one message and receives stop signal only after the push
+type MockPushingConsumer struct {
+	name    string
+	started chan struct{}
+	pushed  chan error
+}
+
+type MockStatusConsumer struct {
+	MockRabbitConsumer
+}
+
 type MockSNSForwarder struct {
 	name string
 }
@@ -121,11 +273,17 @@ type MockLambdaForwarder struct {
 	name string
 }
 
+// MockSlowForwarder push lasts for push time unless its context is cancelled
+type MockSlowForwarder struct {
+	name string
+	push time.Duration
+}
+
 func (c MockRabbitConsumer) Name() string {
 	return c.name
 }
 
-func (c MockRabbitConsumer) Start(client forwarder.Client, check chan bool, stop chan bool) error {
+func (c MockRabbitConsumer) Start(ctx context.Context, client forwarder.Client, check chan bool, stop chan bool) error {
 	go func() {
 		for {
 			select {
@@ -137,11 +295,37 @@ func (c MockRabbitConsumer) Start(client forwarder.Client, check chan bool, stop
 	return nil
 }
 
+func (c MockStoppingConsumer) Name() string {
+	return c.name
+}
+
+func (c MockStoppingConsumer) Start(ctx context.Context, client forwarder.Client, check chan bool, stop chan bool) error {
+	<-stop
+	time.Sleep(c.drain)
+	return nil
+}
+
+func (c MockPushingConsumer) Name() string {
+	return c.name
+}
+
+func (c MockPushingConsumer) Start(ctx context.Context, client forwarder.Client, check chan bool, stop chan bool) error {
+	close(c.started)
+	err := client.Push(ctx, forwarder.Message{Body: "message"})
+	c.pushed <- err
+	<-stop
+	return err
+}
+
+func (c MockStatusConsumer) Status() consumer.Status {
+	return consumer.Status{Delivery: consumer.AtMostOnce, DroppedMessages: 2}
+}
+
 func (f MockSNSForwarder) Name() string {
 	return f.name
 }
 
-func (f MockSNSForwarder) Push(message string) error {
+func (f MockSNSForwarder) Push(ctx context.Context, message forwarder.Message) error {
 	return nil
 }
 
@@ -149,7 +333,7 @@ func (f MockSQSForwarder) Name() string {
 	return f.name
 }
 
-func (f MockSQSForwarder) Push(message string) error {
+func (f MockSQSForwarder) Push(ctx context.Context, message forwarder.Message) error {
 	return nil
 }
 
@@ -157,6 +341,19 @@ func (f MockLambdaForwarder) Name() string {
 	return f.name
 }
 
-func (f MockLambdaForwarder) Push(message string) error {
+func (f MockLambdaForwarder) Push(ctx context.Context, message forwarder.Message) error {
 	return nil
 }
+
+func (f MockSlowForwarder) Name() string {
+	return f.name
+}
+
+func (f MockSlowForwarder) Push(ctx context.Context, message forwarder.Message) error {
+	select {
+	case <-time.After(f.push):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,174 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
+	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
+)
+
+const (
+	notAnObjectError = "message body is not a JSON object"
+	pathSeparator    = "."
+)
+
+// Transformer reshapes messages before forwarding
+type Transformer struct {
+	drop     []string
+	rename   map[string]string
+	envelope string
+	metadata map[string]string
+	template *template.Template
+}
+
+// New creates transformer from mapping entry
+func New(entry config.TransformEntry) (*Transformer, error) {
+	t := &Transformer{
+		drop:     entry.Drop,
+		rename:   entry.Rename,
+		envelope: entry.Envelope,
+		metadata: entry.Metadata,
+	}
+	for field, source := range entry.Metadata {
+		if !forwarder.ValidField(source) {
+			return nil, fmt.Errorf("unknown metadata source %s for field %s", source, field)
+		}
+	}
+	if entry.Template != "" {
+		tmpl, err := template.New("transform").Funcs(template.FuncMap{"json": toJSON}).Parse(entry.Template)
+		if err != nil {
+			return nil, err
+		}
+		t.template = tmpl
+	}
+	return t, nil
+}
+
+// Apply transforms message body. Steps are applied in order: drop, rename, envelope, metadata, template.
+// Body is returned unchanged when no step modifies the message
+func (t *Transformer) Apply(message forwarder.Message) (string, error) {
+	if t == nil {
+		return message.Body, nil
+	}
+	doc, err := fromJSON(message.Body)
+	if err != nil {
+		// non JSON bodies can still be wrapped in an envelope
+		doc = message.Body
+	}
+	modified := false
+	if len(t.drop) > 0 || len(t.rename) > 0 {
+		object, ok := doc.(map[string]interface{})
+		if !ok {
+			return "", errors.New(notAnObjectError)
+		}
+		for _, path := range t.drop {
+			if _, ok := remove(object, path); ok {
+				modified = true
+			}
+		}
+		// sorted for deterministic results of overlapping renames
+		for _, from := range sortedKeys(t.rename) {
+			if value, ok := remove(object, from); ok {
+				set(object, t.rename[from], value)
+				modified = true
+			}
+		}
+	}
+	if t.envelope != "" {
+		doc = map[string]interface{}{t.envelope: doc}
+		modified = true
+	}
+	if len(t.metadata) > 0 {
+		object, ok := doc.(map[string]interface{})
+		if !ok {
+			return "", errors.New(notAnObjectError)
+		}
+		for field, source := range t.metadata {
+			if value, ok := message.Field(source); ok {
+				set(object, field, value)
+				modified = true
+			}
+		}
+	}
+	if t.template != nil {
+		var buf bytes.Buffer
+		if err := t.template.Execute(&buf, doc); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+	if !modified {
+		return message.Body, nil
+	}
+	return toJSON(doc)
+}
+
+// fromJSON decodes body keeping numbers as json.Number, so large integers are not rounded
+func fromJSON(body string) (interface{}, error) {
+	var doc interface{}
+	decoder := json.NewDecoder(strings.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, errors.New("unexpected data after JSON value")
+	}
+	return doc, nil
+}
+
+// toJSON encodes value without escaping HTML characters like <, > and &
+func toJSON(value interface{}) (string, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// remove deletes value under dot separated path
+func remove(object map[string]interface{}, path string) (interface{}, bool) {
+	keys := strings.Split(path, pathSeparator)
+	for _, key := range keys[:len(keys)-1] {
+		child, ok := object[key].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		object = child
+	}
+	last := keys[len(keys)-1]
+	value, ok := object[last]
+	delete(object, last)
+	return value, ok
+}
+
+// set stores value under dot separated path creating missing objects
+func set(object map[string]interface{}, path string, value interface{}) {
+	keys := strings.Split(path, pathSeparator)
+	for _, key := range keys[:len(keys)-1] {
+		child, ok := object[key].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			object[key] = child
+		}
+		object = child
+	}
+	object[keys[len(keys)-1]] = value
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,157 @@
+package transform
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
+	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
+)
+
+func TestNew(t *testing.T) {
+	scenarios := []struct {
+		name  string
+		entry config.TransformEntry
+		valid bool
+	}{
+		{
+			name:  "empty",
+			entry: config.TransformEntry{},
+			valid: true,
+		},
+		{
+			name:  "unknown metadata source",
+			entry: config.TransformEntry{Metadata: map[string]string{"key": "unknown"}},
+			valid: false,
+		},
+		{
+			name:  "broken template",
+			entry: config.TransformEntry{Template: "{{ .id "},
+			valid: false,
+		},
+	}
+	for _, scenario := range scenarios {
+		t.Log("Scenario name: ", scenario.name)
+		_, err := New(scenario.entry)
+		if scenario.valid && err != nil {
+			t.Errorf("Error should not occur. Error: %s", err.Error())
+		}
+		if !scenario.valid && err == nil {
+			t.Errorf("Error should occur")
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	message := forwarder.Message{
+		Body:       `{"id":1,"user":{"name":"john","password":"secret"}}`,
+		RoutingKey: "user.created",
+		Timestamp:  time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC),
+		Headers:    map[string]interface{}{"source": "crm"},
+	}
+	scenarios := []struct {
+		name     string
+		entry    config.TransformEntry
+		message  forwarder.Message
+		expected string
+		err      bool
+	}{
+		{
+			name:     "drop",
+			entry:    config.TransformEntry{Drop: []string{"user.password"}},
+			message:  message,
+			expected: `{"id":1,"user":{"name":"john"}}`,
+		},
+		{
+			name:     "rename",
+			entry:    config.TransformEntry{Rename: map[string]string{"user.name": "userName", "id": "data.id"}},
+			message:  message,
+			expected: `{"data":{"id":1},"user":{"password":"secret"},"userName":"john"}`,
+		},
+		{
+			name:     "envelope with metadata",
+			entry:    config.TransformEntry{Drop: []string{"user"}, Envelope: "detail", Metadata: map[string]string{"routingKey": "routingKey", "sentAt": "timestamp", "source": "headers.source"}},
+			message:  message,
+			expected: `{"detail":{"id":1},"routingKey":"user.created","sentAt":"2017-10-01T12:00:00Z","source":"crm"}`,
+		},
+		{
+			name:     "envelope of plain text",
+			entry:    config.TransformEntry{Envelope: "text"},
+			message:  forwarder.Message{Body: "plain"},
+			expected: `{"text":"plain"}`,
+		},
+		{
+			name:     "plain text pass-through",
+			entry:    config.TransformEntry{},
+			message:  forwarder.Message{Body: "plain text"},
+			expected: "plain text",
+		},
+		{
+			name:     "nothing to drop or rename",
+			entry:    config.TransformEntry{Drop: []string{"user.email"}, Rename: map[string]string{"name": "userName"}},
+			message:  forwarder.Message{Body: `{"user": {"name": "john"}, "id": 1}`},
+			expected: `{"user": {"name": "john"}, "id": 1}`,
+		},
+		{
+			name:    "drop from plain text",
+			entry:   config.TransformEntry{Drop: []string{"id"}},
+			message: forwarder.Message{Body: "plain"},
+			err:     true,
+		},
+		{
+			name:     "template",
+			entry:    config.TransformEntry{Template: `{"userId":{{ .id }},"user":{{ json .user }}}`, Drop: []string{"user.password"}},
+			message:  message,
+			expected: `{"userId":1,"user":{"name":"john"}}`,
+		},
+		{
+			name:     "large numbers",
+			entry:    config.TransformEntry{Envelope: "detail"},
+			message:  forwarder.Message{Body: `{"id":12345678901234567,"amount":1234567.89,"count":20000000}`},
+			expected: `{"detail":{"amount":1234567.89,"count":20000000,"id":12345678901234567}}`,
+		},
+		{
+			name:     "html characters",
+			entry:    config.TransformEntry{Drop: []string{"id"}},
+			message:  forwarder.Message{Body: `{"id":1,"query":"a > b && c < d"}`},
+			expected: `{"query":"a > b && c < d"}`,
+		},
+		{
+			name:     "template with large number",
+			entry:    config.TransformEntry{Template: `{"orderId":{{ .order.id }},"order":{{ json .order }}}`},
+			message:  forwarder.Message{Body: `{"order":{"id":20000000,"note":"<b>"}}`},
+			expected: `{"orderId":20000000,"order":{"id":20000000,"note":"<b>"}}`,
+		},
+	}
+	for _, scenario := range scenarios {
+		t.Log("Scenario name: ", scenario.name)
+		transformer, err := New(scenario.entry)
+		if err != nil {
+			t.Errorf("could not create transformer: %s", err.Error())
+			continue
+		}
+		result, err := transformer.Apply(scenario.message)
+		if scenario.err {
+			if err == nil {
+				t.Errorf("Error should occur")
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Error should not occur. Error: %s", err.Error())
+			continue
+		}
+		if result != scenario.expected {
+			t.Errorf("wrong result, expected:%s, got:%s", scenario.expected, result)
+		}
+	}
+}
+
+func TestApplyWithoutTransformer(t *testing.T) {
+	var transformer *Transformer
+	message := forwarder.Message{Body: "abc"}
+	result, err := transformer.Apply(message)
+	if err != nil || result != message.Body {
+		t.Errorf("message should not be changed, got:%s", result)
+	}
+}
@@ -0,0 +1,195 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
+	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Type forwarder type
+	Type = "HTTP"
+	// DefaultTimeout request timeout
+	DefaultTimeout = 10 * time.Second
+	// DefaultSignatureHeader header with HMAC signature of the message
+	DefaultSignatureHeader = "X-Signature-256"
+	// MaxRetryDelay maximum delay between retries
+	MaxRetryDelay    = 30 * time.Second
+	signaturePrefix  = "sha256="
+	contentType      = "Content-Type"
+	jsonType         = "application/json"
+	retryAfterHeader = "Retry-After"
+	firstRetryDelay  = 200 * time.Millisecond
+)
+
+// Forwarder forwarding client
+type Forwarder struct {
+	name            string
+	httpClient      *http.Client
+	url             string
+	method          string
+	headers         map[string]string
+	successCodes    []int
+	retries         int
+	secret          []byte
+	signatureHeader string
+}
+
+// CreateForwarder creates instance of forwarder
+func CreateForwarder(entry config.AmazonEntry, httpClient ...*http.Client) forwarder.Client {
+	timeout, err := forwarder.Timeout(entry.Timeout, DefaultTimeout)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"forwarderName": entry.Name,
+			"error":         err.Error()}).Fatal("Invalid push timeout")
+	}
+	var client *http.Client
+	if len(httpClient) > 0 {
+		client = httpClient[0]
+	} else {
+		client = &http.Client{Timeout: timeout}
+	}
+	method := entry.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	var secret []byte
+	if entry.HMACSecretEnv != "" {
+		if secret = []byte(os.Getenv(entry.HMACSecretEnv)); len(secret) == 0 {
+			log.WithFields(log.Fields{
+				"forwarderName": entry.Name,
+				"hmacSecretEnv": entry.HMACSecretEnv}).Fatal("HMAC secret is empty")
+		}
+	}
+	signatureHeader := entry.SignatureHeader
+	if signatureHeader == "" {
+		signatureHeader = DefaultSignatureHeader
+	}
+	forwarder := Forwarder{entry.Name, client, entry.Target, method, entry.Headers, entry.SuccessCodes, entry.Retries, secret, signatureHeader}
+	log.WithField("forwarderName", forwarder.Name()).Info("Created forwarder")
+	return forwarder
+}
+
+// Name forwarder name
+func (f Forwarder) Name() string {
+	return f.name
+}
+
+// Push pushes message to forwarding infrastructure, retryable failures are retried with exponential backoff.
+// Requests and waits between retries are cancelled when context is done
+func (f Forwarder) Push(ctx context.Context, msg forwarder.Message) error {
+	message := msg.Body
+	if message == "" {
+		return errors.New(forwarder.EmptyMessageError)
+	}
+	delay := firstRetryDelay
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := f.send(ctx, message)
+		if err == nil || !forwarder.IsRetryable(err) || attempt >= f.retries {
+			return err
+		}
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+		if delay > MaxRetryDelay {
+			delay = MaxRetryDelay
+		}
+		log.WithFields(log.Fields{
+			"forwarderName": f.Name(),
+			"attempt":       attempt + 1,
+			"delay":         delay.String(),
+			"error":         err.Error()}).Warn("Retrying message forwarding")
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+// send sends single request and returns delay requested by the server with Retry-After header
+func (f Forwarder) send(ctx context.Context, message string) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, f.method, f.url, bytes.NewBufferString(message))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set(contentType, jsonType)
+	for name, value := range f.headers {
+		req.Header.Set(name, value)
+	}
+	if f.secret != nil {
+		req.Header.Set(f.signatureHeader, signaturePrefix+Sign(f.secret, message))
+	}
+	resp, err := f.httpClient.Do(req)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"forwarderName": f.Name(),
+			"error":         err.Error()}).Error("Could not forward message")
+		return 0, forwarder.RetryableError{Err: err}
+	}
+	defer resp.Body.Close()
+	// drain body to reuse connection
+	io.Copy(ioutil.Discard, resp.Body)
+	if f.success(resp.StatusCode) {
+		log.WithFields(log.Fields{
+			"forwarderName": f.Name(),
+			"statusCode":    resp.StatusCode}).Info("Forward succeeded")
+		return 0, nil
+	}
+	log.WithFields(log.Fields{
+		"forwarderName": f.Name(),
+		"statusCode":    resp.StatusCode}).Error("Could not forward message")
+	err = fmt.Errorf("unexpected response status: %s", resp.Status)
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError {
+		return retryAfter(resp.Header.Get(retryAfterHeader), time.Now()), forwarder.RetryableError{Err: err}
+	}
+	return 0, err
+}
+
+// retryAfter delay requested with Retry-After header in seconds or as HTTP date, zero when missing or invalid
+func retryAfter(value string, now time.Time) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	date, err := http.ParseTime(value)
+	if err != nil || !date.After(now) {
+		return 0
+	}
+	return date.Sub(now)
+}
+
+func (f Forwarder) success(statusCode int) bool {
+	if len(f.successCodes) == 0 {
+		return statusCode >= 200 && statusCode < 300
+	}
+	for _, code := range f.successCodes {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// Sign hex encoded HMAC SHA256 signature of the message
+func Sign(secret []byte, message string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
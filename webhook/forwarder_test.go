@@ -0,0 +1,212 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/AirHelp/rabbit-amazon-forwarder/config"
+	"github.com/AirHelp/rabbit-amazon-forwarder/forwarder"
+)
+
+const secretEnv = "HTTP_FORWARDER_TEST_SECRET"
+
+func TestCreateForwarder(t *testing.T) {
+	entry := config.AmazonEntry{Type: "HTTP",
+		Name:   "http-test",
+		Target: "http://localhost",
+	}
+	forwarder := CreateForwarder(entry)
+	if forwarder.Name() != entry.Name {
+		t.Errorf("wrong forwarder name, expected:%s, found: %s", entry.Name, forwarder.Name())
+	}
+}
+
+func TestPush(t *testing.T) {
+	os.Setenv(secretEnv, "secret")
+	var requests []*http.Request
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, r)
+		bodies = append(bodies, string(body))
+		switch string(body) {
+		case "created":
+			w.WriteHeader(http.StatusCreated)
+		case "bad":
+			w.WriteHeader(http.StatusBadRequest)
+		case "throttled":
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "unavailable":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	scenarios := []struct {
+		name      string
+		entry     config.AmazonEntry
+		message   string
+		requests  int
+		err       error
+		retryable bool
+	}{
+		{
+			name:    "empty message",
+			message: "",
+			err:     errors.New(forwarder.EmptyMessageError),
+		},
+		{
+			name:     "success",
+			message:  "abc",
+			requests: 1,
+		},
+		{
+			name:     "client error",
+			message:  "bad",
+			requests: 1,
+			err:      errors.New("unexpected response status: 400 Bad Request"),
+		},
+		{
+			name:     "status not in success codes",
+			entry:    config.AmazonEntry{SuccessCodes: []int{200}},
+			message:  "created",
+			requests: 1,
+			err:      errors.New("unexpected response status: 201 Created"),
+		},
+		{
+			name:      "throttling",
+			message:   "throttled",
+			requests:  1,
+			err:       errors.New("unexpected response status: 429 Too Many Requests"),
+			retryable: true,
+		},
+		{
+			name:      "server error with retries",
+			entry:     config.AmazonEntry{Retries: 2},
+			message:   "unavailable",
+			requests:  3,
+			err:       errors.New("unexpected response status: 503 Service Unavailable"),
+			retryable: true,
+		},
+	}
+	for _, scenario := range scenarios {
+		t.Log("Scenario name: ", scenario.name)
+		requests, bodies = nil, nil
+		scenario.entry.Type, scenario.entry.Name, scenario.entry.Target = "HTTP", "http-test", server.URL
+		client := CreateForwarder(scenario.entry)
+		err := client.Push(context.Background(), forwarder.Message{Body: scenario.message})
+		if len(requests) != scenario.requests {
+			t.Errorf("wrong number of requests, expected:%d, got:%d", scenario.requests, len(requests))
+		}
+		if scenario.err == nil {
+			if err != nil {
+				t.Errorf("Error should not occur. Error: %s", err.Error())
+			}
+			continue
+		}
+		if err == nil || err.Error() != scenario.err.Error() {
+			t.Errorf("Wrong error, expecting:%v, got:%v", scenario.err, err)
+			continue
+		}
+		if forwarder.IsRetryable(err) != scenario.retryable {
+			t.Errorf("wrong retryable error, expected:%t", scenario.retryable)
+		}
+	}
+}
+
+func TestPushCancelled(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) == "slow" {
+			<-r.Context().Done()
+			return
+		}
+		w.Header().Set(retryAfterHeader, "10")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	entry := config.AmazonEntry{Type: "HTTP", Name: "http-test", Target: server.URL, Retries: 3}
+	client := CreateForwarder(entry).(Forwarder)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := client.Push(ctx, forwarder.Message{Body: "unavailable"})
+	if !forwarder.IsRetryable(err) {
+		t.Errorf("retryable error should be returned when waiting is cancelled, got: %v", err)
+	}
+	if requests != 1 || time.Since(start) > 5*time.Second {
+		t.Errorf("retry wait should be cancelled, got %d requests in %s", requests, time.Since(start))
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	if err := client.Push(ctx, forwarder.Message{Body: "slow"}); err == nil {
+		t.Errorf("cancelled request should fail")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("request should be cancelled with context, took %s", time.Since(start))
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+	scenarios := []struct {
+		name     string
+		value    string
+		expected time.Duration
+	}{
+		{name: "missing", value: "", expected: 0},
+		{name: "seconds", value: "10", expected: 10 * time.Second},
+		{name: "HTTP date", value: "Sun, 01 Oct 2017 12:00:20 GMT", expected: 20 * time.Second},
+		{name: "past HTTP date", value: "Sun, 01 Oct 2017 11:59:00 GMT", expected: 0},
+		{name: "invalid", value: "soon", expected: 0},
+	}
+	for _, scenario := range scenarios {
+		t.Log("Scenario name: ", scenario.name)
+		if delay := retryAfter(scenario.value, now); delay != scenario.expected {
+			t.Errorf("wrong delay, expected:%s, got:%s", scenario.expected, delay)
+		}
+	}
+}
+
+func TestPushRequest(t *testing.T) {
+	os.Setenv(secretEnv, "secret")
+	var request *http.Request
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		request, body = r, string(data)
+	}))
+	defer server.Close()
+
+	entry := config.AmazonEntry{Type: "HTTP",
+		Name:          "http-test",
+		Target:        server.URL + "/hook",
+		Method:        http.MethodPut,
+		Headers:       map[string]string{"Authorization": "Bearer token"},
+		HMACSecretEnv: secretEnv,
+	}
+	if err := CreateForwarder(entry).Push(context.Background(), forwarder.Message{Body: `{"id":1}`}); err != nil {
+		t.Fatalf("Error should not occur. Error: %s", err.Error())
+	}
+	if request.Method != http.MethodPut || request.URL.Path != "/hook" || body != `{"id":1}` {
+		t.Errorf("wrong request: %s %s %s", request.Method, request.URL.Path, body)
+	}
+	if request.Header.Get("Authorization") != "Bearer token" || request.Header.Get(contentType) != jsonType {
+		t.Errorf("wrong request headers: %v", request.Header)
+	}
+	expected := signaturePrefix + "03def589620c813f198fd03d7967e292b163ef0435ebf43071ce0e9519763cb7"
+	if signature := request.Header.Get(DefaultSignatureHeader); signature != expected {
+		t.Errorf("wrong signature: %s", signature)
+	}
+}